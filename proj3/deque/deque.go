@@ -15,10 +15,12 @@ type Task struct {
 //When popbottom, bottom-- (shrink upwards). When poptop, top++, meaning the next element to poptop is the one lower.
 
 type DEQueue struct {
+	// top holds the index of the top task in its low 32 bits and a stamp in its high 32 bits.
+	// Both change in one CAS, so a thief holding a stale top fails even when the owner reset
+	// the index to the same value (ABA). First field to keep the 64 bits atomics aligned.
+	top    uint64
 	Tasks  []*Task
-	top    int32
 	bottom int32
-	stamp  int64 // Atomic stamp to manage versioning and detect ABA problems
 }
 
 func NewDEQueue(size int) *DEQueue {
@@ -26,12 +28,22 @@ func NewDEQueue(size int) *DEQueue {
 		Tasks:  make([]*Task, size),
 		top:    0,
 		bottom: 0,
-		stamp:  0,
 	}
 }
 
+// packTop builds the top word from the index and the stamp
+func packTop(top int32, stamp uint32) uint64 {
+	return uint64(stamp)<<32 | uint64(uint32(top))
+}
+
+// unpackTop splits the top word into the index and the stamp
+func unpackTop(word uint64) (int32, uint32) {
+	return int32(uint32(word)), uint32(word >> 32)
+}
+
 func (q *DEQueue) GetTop() int32 {
-	return atomic.LoadInt32(&q.top)
+	top, _ := unpackTop(atomic.LoadUint64(&q.top))
+	return top
 }
 
 func (q *DEQueue) GetBottom() int32 {
@@ -52,18 +64,16 @@ func (q *DEQueue) PushBottom(task *Task) bool {
 }
 
 func (q *DEQueue) PopTop() (*Task, bool) {
-	localTop := atomic.LoadInt32(&q.top)
+	oldTop := atomic.LoadUint64(&q.top)
+	localTop, stamp := unpackTop(oldTop)
 	localBottom := atomic.LoadInt32(&q.bottom)
 	if localBottom <= localTop {
 		return nil, false // Queue is empty or in an inconsistent state
 	}
 
 	task := q.Tasks[localTop]
-	oldStamp := atomic.LoadInt64(&q.stamp)
-	newStamp := oldStamp + 1
-
-	if atomic.CompareAndSwapInt32(&q.top, localTop, localTop+1) {
-		atomic.CompareAndSwapInt64(&q.stamp, oldStamp, newStamp)
+	// fails if anyone moved top or bumped the stamp since it was read
+	if atomic.CompareAndSwapUint64(&q.top, oldTop, packTop(localTop+1, stamp+1)) {
 		return task, true
 	}
 	return nil, false
@@ -77,10 +87,11 @@ func (q *DEQueue) PopBottom() (*Task, bool) {
 	localBottom--
 	atomic.StoreInt32(&q.bottom, localBottom)
 
-	localTop := atomic.LoadInt32(&q.top)
 	task := q.Tasks[localBottom]
-	oldStamp := atomic.LoadInt64(&q.stamp)
-	newStamp := oldStamp + 1
+	oldTop := atomic.LoadUint64(&q.top)
+	localTop, stamp := unpackTop(oldTop)
+	// resetting top always takes a new stamp, so a thief that read the old top loses its CAS
+	emptyTop := packTop(0, stamp+1)
 
 	// Top and bottom one or mar apart, no conflict
 	if localBottom > localTop {
@@ -89,20 +100,19 @@ func (q *DEQueue) PopBottom() (*Task, bool) {
 	if localBottom == localTop { // last element
 		// if I win, bottom is 0. If I lose, thief must have won, bottom is 0.
 		atomic.StoreInt32(&q.bottom, 0)
-		if atomic.CompareAndSwapInt32(&q.top, localTop, 0) {
-			atomic.CompareAndSwapInt64(&q.stamp, oldStamp, newStamp)
+		if atomic.CompareAndSwapUint64(&q.top, oldTop, emptyTop) {
 			return task, true
 		}
 	}
-	// Failed to pop last task, restore the bottom and top since DEQueue is empty
-	atomic.StoreInt32(&q.top, 0)
-	atomic.StoreInt64(&q.stamp, int64(newStamp))
-	atomic.AddInt32(&q.bottom, 0)
+	// Failed to pop last task, restore the bottom and top since DEQueue is empty.
+	// bottom goes first, otherwise a thief could see top 0 below the old bottom and take a task again
+	atomic.StoreInt32(&q.bottom, 0)
+	atomic.StoreUint64(&q.top, emptyTop)
 	return nil, false
 }
 
 func (q *DEQueue) IsEmpty() bool {
-	localTop := atomic.LoadInt32(&q.top)
+	localTop := q.GetTop()
 	localBottom := atomic.LoadInt32(&q.bottom)
 	return localTop >= localBottom
 }
@@ -0,0 +1,82 @@
+package deque
+
+import (
+	"image"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// runRound has the owner pop from the bottom while thieves pop from the top until the
+// deque is empty, and returns how many times each task was handed out
+func runRound(tasks, thieves int) []int32 {
+	q := NewDEQueue(tasks)
+	for i := 0; i < tasks; i++ {
+		q.PushBottom(&Task{Bounds: image.Rect(i, 0, i+1, 1)})
+	}
+	taken := make([]int32, tasks)
+	take := func(task *Task) {
+		atomic.AddInt32(&taken[task.Bounds.Min.X], 1)
+	}
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < thieves; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for !q.IsEmpty() {
+				if task, ok := q.PopTop(); ok {
+					take(task)
+				}
+				runtime.Gosched()
+			}
+		}()
+	}
+	close(start)
+	for {
+		task, ok := q.PopBottom()
+		if !ok {
+			break
+		}
+		take(task)
+	}
+	wg.Wait()
+	return taken
+}
+
+// Every task must be handed out exactly once, the last one too, or a Reduce pass
+// counts a slice twice
+func TestEveryTaskTakenOnce(t *testing.T) {
+	for round := 0; round < 20000; round++ {
+		tasks := 1 + round%4
+		for i, n := range runRound(tasks, 3) {
+			if n != 1 {
+				t.Fatalf("round %d: task %d of %d taken %d times", round, i, tasks, n)
+			}
+		}
+	}
+}
+
+// A thief that read top before the owner popped the last task must lose its CAS, even
+// though the owner leaves the index of top at 0 as it was
+func TestStaleThiefLosesLastTask(t *testing.T) {
+	q := NewDEQueue(1)
+	q.PushBottom(&Task{})
+
+	// the thief reads top and bottom, the deque holds one task...
+	staleTop := atomic.LoadUint64(&q.top)
+	localTop, stamp := unpackTop(staleTop)
+	if q.GetBottom() <= localTop {
+		t.Fatal("the deque should hold a task")
+	}
+	// ...then the owner takes it
+	if _, ok := q.PopBottom(); !ok {
+		t.Fatal("the owner should get the last task")
+	}
+	if atomic.CompareAndSwapUint64(&q.top, staleTop, packTop(localTop+1, stamp+1)) {
+		t.Fatal("the thief took the task the owner already popped")
+	}
+}
@@ -3,11 +3,15 @@ editor.go takes in 3 arguments
 which will be initialize in config : input files, mode, threadcount
 then pass 'config' to the scheduler.Schedule(config)
 which apply the effects
+
+The location of effects.txt and of the input/output roots can be changed with
+the -effects, -in and -out flags, which must come before the arguments.
 */
 
 package main
 
 import (
+	"flag"
 	"fmt"		// for formatted I/O operations
 	"os"
 	"proj3/scheduler"		// scheduling and managing the image processing tasks
@@ -16,15 +20,33 @@ import (
 )
 
 
-const usage = "Usage: editor data_dir mode [number of threads]\n" +
+const usage = "Usage: editor [flags] data_dir mode [number of threads]\n" +
 	"data_dir = The data directory to use to load the images.\n" +
 	"mode     = (s) run sequentially, (parfiles) process multiple files in parallel, (parslices) process slices of each image in parallel \n" +
-	"[number of threads] = Runs the parallel version of the program with the specified number of threads.\n"
+	"[number of threads] = Runs the parallel version of the program with the specified number of threads.\n" +
+	"flags:\n"
 
 func main() {
 
-	if len(os.Args) < 2 {
-		fmt.Println(usage)
+	effectsPath := flag.String("effects", scheduler.DefaultEffectsPath, "path to the effects.txt file")
+	inDir := flag.String("in", scheduler.DefaultInDir, "root directory holding the data_dir input folders")
+	outDir := flag.String("out", scheduler.DefaultOutDir, "directory the output images are written to")
+	strict := flag.Bool("strict", true, "fail the run on a task with an unknown effect; -strict=false skips such tasks instead")
+	stats := flag.Bool("stats", false, "write the statistics of each output image to a .json file next to it")
+	quality := flag.Int("quality", 0, "quality of the JPEG outputs, 1-100 (default the encoder's 75)")
+	compression := flag.String("compression", "", "compression of the PNG outputs: default, none, speed or best")
+	depth := flag.String("depth", "", "bit depth of the outputs: preserve (default), rgba8, rgba16, gray8 or gray16")
+	recordEffects := flag.Bool("record-effects", false, "write the effects applied into a text chunk of each PNG output")
+	loadThreads := flag.Int("load-threads", 0, "goroutines converting each loaded image in the slice modes (default the number of threads)")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args := flag.Args()
+
+	if len(args) < 1 {
+		flag.Usage()
 		return
 	}
 
@@ -33,20 +55,49 @@ func main() {
 	// - Mode : 's', 'parfiles', 'parslices'
 	// - ThreadCount : if not provide is Sequential mode
 	// ie $: go run editor.go big+small pipeline 2
+	//    $: go run editor.go -in /mnt/images -out /tmp/out small parslices 4
 
 	config := scheduler.Config{DataDirs: "", Mode: "", ThreadCount: 0}
-	config.DataDirs = os.Args[1]
+	config.DataDirs = args[0]
+	config.EffectsPath = *effectsPath
+	config.InDir = *inDir
+	config.OutDir = *outDir
+	config.Strict = *strict
+	config.Stats = *stats
+	config.LoadThreads = *loadThreads
+	config.JPEGQuality = *quality
+	config.Compression = *compression
+	config.Depth = *depth
+	config.RecordEffects = *recordEffects
 
-	if len(os.Args) >= 3 {
-		config.Mode = os.Args[2]
-		threads, _ := strconv.Atoi(os.Args[3])
-		config.ThreadCount = threads
+	if len(args) >= 2 {
+		config.Mode = args[1]
+		if config.Mode != "s" {
+			if len(args) < 3 {
+				fmt.Fprintf(os.Stderr, "editor: mode %s needs a number of threads\n", config.Mode)
+				os.Exit(2)
+			}
+			threads, err := strconv.Atoi(args[2])
+			if err != nil || threads < 1 {
+				fmt.Fprintf(os.Stderr, "editor: invalid number of threads %q, must be at least 1\n", args[2])
+				os.Exit(2)
+			}
+			config.ThreadCount = threads
+		}
 	} else {
 		config.Mode = "s"
 	}
 	start := time.Now()
-	scheduler.Schedule(config)		// run task
+	results, err := scheduler.Schedule(config)		// run task
 	end := time.Since(start).Seconds()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "editor:", err)
+		os.Exit(1)
+	}
+	fmt.Print(results.Summary())
 	fmt.Printf("  Program time: %.2f\n", end)		// Measure Program time
 
+	if results.Failed() > 0 {
+		os.Exit(1)		// let scripts notice that some images were not written
+	}
 }
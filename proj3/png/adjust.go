@@ -0,0 +1,158 @@
+package png
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// adjustFunc maps straight (not premultiplied) r, g, b in [0, 1] to new values
+type adjustFunc func(r, g, b float64) (float64, float64, float64)
+
+// adjust applies f to every pixel of bounds. The result is clamped and premultiplied again,
+// the alpha is kept.
+func (img *Image) adjust(f adjustFunc, bounds image.Rectangle) {
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := img.In.RGBA64At(x, y)
+			if c.A == 0 {
+				img.Out.SetRGBA64(x, y, color.RGBA64{})
+				continue
+			}
+			a := float64(c.A)
+			r, g, b := f(float64(c.R)/a, float64(c.G)/a, float64(c.B)/a)
+			img.Out.SetRGBA64(x, y, color.RGBA64{
+				R: premultiply(clamp(r*65535), c.A),
+				G: premultiply(clamp(g*65535), c.A),
+				B: premultiply(clamp(b*65535), c.A),
+				A: c.A,
+			})
+		}
+	}
+}
+
+// premultiply scales a straight channel value by alpha
+func premultiply(v, a uint16) uint16 {
+	return uint16(uint32(v) * uint32(a) / 65535)
+}
+
+// Brightness adds amount (on the 0-255 scale, may be negative) to each channel
+func (img *Image) Brightness(amount float64, boundaries ...image.Rectangle) {
+	img.adjust(brightness(amount), img.GetBoundary(boundaries...))
+}
+
+// Contrast scales each channel away from (factor > 1) or towards (factor < 1) mid gray
+func (img *Image) Contrast(factor float64, boundaries ...image.Rectangle) {
+	img.adjust(contrast(factor), img.GetBoundary(boundaries...))
+}
+
+// Gamma applies the gamma curve v^(1/gamma), so gamma > 1 brightens the mid tones
+func (img *Image) Gamma(gamma float64, boundaries ...image.Rectangle) {
+	img.adjust(gammaCurve(gamma), img.GetBoundary(boundaries...))
+}
+
+// Saturation scales the HSV saturation by factor, 0 gives gray
+func (img *Image) Saturation(factor float64, boundaries ...image.Rectangle) {
+	img.adjust(saturation(factor), img.GetBoundary(boundaries...))
+}
+
+// HueRotate rotates the HSV hue by degrees
+func (img *Image) HueRotate(degrees float64, boundaries ...image.Rectangle) {
+	img.adjust(hueRotate(degrees), img.GetBoundary(boundaries...))
+}
+
+func brightness(amount float64) adjustFunc {
+	offset := amount / 255
+	return func(r, g, b float64) (float64, float64, float64) {
+		return r + offset, g + offset, b + offset
+	}
+}
+
+func contrast(factor float64) adjustFunc {
+	return func(r, g, b float64) (float64, float64, float64) {
+		return (r-0.5)*factor + 0.5, (g-0.5)*factor + 0.5, (b-0.5)*factor + 0.5
+	}
+}
+
+func gammaCurve(gamma float64) adjustFunc {
+	inv := 1 / gamma
+	return func(r, g, b float64) (float64, float64, float64) {
+		return math.Pow(r, inv), math.Pow(g, inv), math.Pow(b, inv)
+	}
+}
+
+func saturation(factor float64) adjustFunc {
+	return func(r, g, b float64) (float64, float64, float64) {
+		h, s, v := rgbToHSV(r, g, b)
+		return hsvToRGB(h, math.Min(1, s*factor), v)
+	}
+}
+
+func hueRotate(degrees float64) adjustFunc {
+	return func(r, g, b float64) (float64, float64, float64) {
+		h, s, v := rgbToHSV(r, g, b)
+		return hsvToRGB(h+degrees, s, v)
+	}
+}
+
+// adjustParams is the JSON form of the effects ie. {"name": "contrast", "amount": 1.5}.
+// gamma takes {"gamma": 2.2} and hue {"degrees": 90}.
+type adjustParams struct {
+	Amount  *float64 `json:"amount"`
+	Gamma   *float64 `json:"gamma"`
+	Degrees *float64 `json:"degrees"`
+}
+
+// adjustFactory returns the factory of a color adjustment, build makes the adjustFunc
+// from its parameter
+func adjustFactory(name string, build func(v float64) (adjustFunc, error), param func(p adjustParams) *float64) EffectFactory {
+	return func(params json.RawMessage) (Effect, error) {
+		var p adjustParams
+		if params != nil {
+			if err := decodeParams(params, &p); err != nil {
+				return Effect{}, err
+			}
+		}
+		v := param(p)
+		if v == nil {
+			return Effect{}, fmt.Errorf("missing parameter")
+		}
+		f, err := build(*v)
+		if err != nil {
+			return Effect{}, err
+		}
+		return Effect{Name: name, Pointwise: true, Apply: func(img *Image, bounds image.Rectangle) {
+			img.adjust(f, bounds)
+		}}, nil
+	}
+}
+
+func registerAdjustEffects() {
+	amount := func(p adjustParams) *float64 { return p.Amount }
+	RegisterEffectFactory("brightness", adjustFactory("brightness", func(v float64) (adjustFunc, error) {
+		return brightness(v), nil
+	}, amount))
+	RegisterEffectFactory("contrast", adjustFactory("contrast", func(v float64) (adjustFunc, error) {
+		if v < 0 {
+			return nil, fmt.Errorf("contrast must not be negative, got %v", v)
+		}
+		return contrast(v), nil
+	}, amount))
+	RegisterEffectFactory("gamma", adjustFactory("gamma", func(v float64) (adjustFunc, error) {
+		if v <= 0 {
+			return nil, fmt.Errorf("gamma must be positive, got %v", v)
+		}
+		return gammaCurve(v), nil
+	}, func(p adjustParams) *float64 { return p.Gamma }))
+	RegisterEffectFactory("saturation", adjustFactory("saturation", func(v float64) (adjustFunc, error) {
+		if v < 0 {
+			return nil, fmt.Errorf("saturation must not be negative, got %v", v)
+		}
+		return saturation(v), nil
+	}, amount))
+	RegisterEffectFactory("hue", adjustFactory("hue", func(v float64) (adjustFunc, error) {
+		return hueRotate(v), nil
+	}, func(p adjustParams) *float64 { return p.Degrees }))
+}
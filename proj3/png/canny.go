@@ -0,0 +1,173 @@
+package png
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sync"
+)
+
+// Pixel classes after the double threshold
+const (
+	cannyNone   uint8 = iota
+	cannyWeak         // Kept only if connected to a strong pixel
+	cannyStrong       // Always an edge
+)
+
+// cannyState holds the per image buffers shared by the stages of one Canny effect
+type cannyState struct {
+	once      sync.Once
+	magnitude []float32 // Sobel gradient magnitude
+	direction []uint8   // Gradient direction rounded to 0, 45, 90 or 135 degrees, as 0..3
+	class     []uint8   // cannyNone, cannyWeak or cannyStrong after suppression
+}
+
+func (state *cannyState) init(bounds image.Rectangle) {
+	state.once.Do(func() {
+		n := bounds.Dx() * bounds.Dy()
+		state.magnitude = make([]float32, n)
+		state.direction = make([]uint8, n)
+		state.class = make([]uint8, n)
+	})
+}
+
+// neighbour offsets along the gradient for each rounded direction
+var cannyOffsets = [4][2]int{{1, 0}, {1, 1}, {0, 1}, {-1, 1}}
+
+// NewCanny returns the Canny edge detector as a compound effect: Gaussian smoothing,
+// Sobel gradient, non-maximum suppression with the double threshold, then hysteresis.
+// low and high are thresholds on the gradient magnitude, on the 0-255 scale.
+// Hysteresis follows edges across the whole image, so it is a Global pass.
+func NewCanny(sigma, low, high float64) Effect {
+	state := &cannyState{}
+	var passes []Effect
+	if sigma > 0 {
+		passes = append(passes, GaussianKernel(sigma).Passes("canny/gaussian")...)
+	}
+	passes = append(passes,
+		Effect{Name: "canny/gradient", Radius: 1, NoSwap: true, Apply: func(img *Image, bounds image.Rectangle) {
+			state.init(img.Bounds)
+			img.cannyGradient(state, bounds)
+		}},
+		Effect{Name: "canny/suppress", Radius: 1, NoSwap: true, Apply: func(img *Image, bounds image.Rectangle) {
+			img.cannySuppress(state, low*257, high*257, bounds)
+		}},
+		Effect{Name: "canny/hysteresis", Global: true, Apply: func(img *Image, bounds image.Rectangle) {
+			img.cannyHysteresis(state)
+		}},
+	)
+	return Effect{Name: "canny", Radius: 1, Passes: passes}
+}
+
+// cannyGradient stores the Sobel magnitude and rounded direction of In
+func (img *Image) cannyGradient(state *cannyState, bounds image.Rectangle) {
+	full := img.Bounds
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			gx, gy := img.gradientAt(Sobel, EdgeClamp, color.RGBA64{}, x, y)
+			i := (y-full.Min.Y)*full.Dx() + (x - full.Min.X)
+			state.magnitude[i] = float32(math.Hypot(gx, gy))
+
+			angle := math.Atan2(gy, gx) * 180 / math.Pi
+			if angle < 0 {
+				angle += 180
+			}
+			state.direction[i] = uint8(int(math.Round(angle/45)) % 4)
+		}
+	}
+}
+
+// cannySuppress keeps the pixels that are a local maximum along their gradient and
+// classifies them against the two thresholds
+func (img *Image) cannySuppress(state *cannyState, low, high float64, bounds image.Rectangle) {
+	full := img.Bounds
+	at := func(x, y int) float32 {
+		x = EdgeClamp.index(x, full.Min.X, full.Max.X)
+		y = EdgeClamp.index(y, full.Min.Y, full.Max.Y)
+		return state.magnitude[(y-full.Min.Y)*full.Dx()+(x-full.Min.X)]
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			i := (y-full.Min.Y)*full.Dx() + (x - full.Min.X)
+			m := state.magnitude[i]
+			off := cannyOffsets[state.direction[i]]
+
+			class := cannyNone
+			if m >= at(x+off[0], y+off[1]) && m >= at(x-off[0], y-off[1]) {
+				if float64(m) >= high {
+					class = cannyStrong
+				} else if float64(m) >= low {
+					class = cannyWeak
+				}
+			}
+			state.class[i] = class
+		}
+	}
+}
+
+// cannyHysteresis grows the strong pixels through the connected weak pixels and
+// writes the edges white on black into Out
+func (img *Image) cannyHysteresis(state *cannyState) {
+	full := img.Bounds
+	width, height := full.Dx(), full.Dy()
+
+	var stack []int
+	for i, class := range state.class {
+		if class == cannyStrong {
+			stack = append(stack, i)
+		}
+	}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		x, y := i%width, i/width
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				nx, ny := x+dx, y+dy
+				if nx < 0 || ny < 0 || nx >= width || ny >= height {
+					continue
+				}
+				if j := ny*width + nx; state.class[j] == cannyWeak {
+					state.class[j] = cannyStrong
+					stack = append(stack, j)
+				}
+			}
+		}
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			px, py := full.Min.X+x, full.Min.Y+y
+			a := img.In.RGBA64At(px, py).A
+			var v uint16
+			if state.class[y*width+x] == cannyStrong {
+				v = a // white, premultiplied
+			}
+			img.Out.SetRGBA64(px, py, color.RGBA64{v, v, v, a})
+		}
+	}
+}
+
+// cannyParams is the JSON form of the effect ie. {"name": "canny", "sigma": 1.4, "low": 20, "high": 50}
+type cannyParams struct {
+	Sigma float64 `json:"sigma"`
+	Low   float64 `json:"low"`
+	High  float64 `json:"high"`
+}
+
+// newCannyEffect is the factory of the "canny" effect
+func newCannyEffect(params json.RawMessage) (Effect, error) {
+	p := cannyParams{Sigma: 1.4, Low: 20, High: 50}
+	if params != nil {
+		if err := decodeParams(params, &p); err != nil {
+			return Effect{}, err
+		}
+	}
+	if p.Sigma < 0 || p.Sigma > MaxSigma || p.Low < 0 || p.High < p.Low {
+		return Effect{}, fmt.Errorf("need 0 <= sigma <= %d and 0 <= low <= high, got sigma %v low %v high %v", MaxSigma, p.Sigma, p.Low, p.High)
+	}
+	return NewCanny(p.Sigma, p.Low, p.High), nil
+}
@@ -0,0 +1,78 @@
+package png
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// ChromaKey makes the pixels close to the key color transparent, ie. to remove a black
+// or green background. A pixel is keyed when none of its straight channels differs from
+// the key by more than tolerance, on the 0-255 scale. Over the next feather levels the
+// alpha rises back linearly, which softens the cut-out edges.
+func (img *Image) ChromaKey(key color.RGBA, tolerance, feather float64, boundaries ...image.Rectangle) {
+	bounds := img.GetBoundary(boundaries...)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := img.In.RGBA64At(x, y)
+			r, g, b, a := img.straight(x, y)
+			if a == 0 {
+				img.Out.SetRGBA64(x, y, c)
+				continue
+			}
+
+			distance := math.Max(math.Abs(r/257-float64(key.R)),
+				math.Max(math.Abs(g/257-float64(key.G)), math.Abs(b/257-float64(key.B))))
+			keep := 1.0 // share of the alpha kept
+			if distance <= tolerance {
+				keep = 0
+			} else if distance < tolerance+feather {
+				keep = (distance - tolerance) / feather
+			}
+
+			// scaling every premultiplied channel by the same factor keeps the straight color
+			img.Out.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(float64(c.R)*keep + 0.5),
+				G: uint16(float64(c.G)*keep + 0.5),
+				B: uint16(float64(c.B)*keep + 0.5),
+				A: uint16(float64(c.A)*keep + 0.5),
+			})
+		}
+	}
+}
+
+// chromaKeyParams is the JSON form of the effect ie.
+// {"name": "chromakey", "color": [0, 255, 0], "tolerance": 40, "feather": 20}
+type chromaKeyParams struct {
+	Color     []int   `json:"color"`
+	Tolerance float64 `json:"tolerance"`
+	Feather   float64 `json:"feather"`
+}
+
+// newChromaKeyEffect is the factory of the "chromakey" effect. The key defaults to
+// black with no tolerance, which removes the pure black pixels.
+func newChromaKeyEffect(params json.RawMessage) (Effect, error) {
+	p := chromaKeyParams{Color: []int{0, 0, 0}}
+	if params != nil {
+		if err := decodeParams(params, &p); err != nil {
+			return Effect{}, err
+		}
+	}
+	if len(p.Color) != 3 {
+		return Effect{}, fmt.Errorf("color needs 3 values, got %d", len(p.Color))
+	}
+	for _, v := range p.Color {
+		if v < 0 || v > 255 {
+			return Effect{}, fmt.Errorf("color values must be in 0..255, got %d", v)
+		}
+	}
+	if p.Tolerance < 0 || p.Feather < 0 {
+		return Effect{}, fmt.Errorf("tolerance and feather must not be negative")
+	}
+	key := color.RGBA{uint8(p.Color[0]), uint8(p.Color[1]), uint8(p.Color[2]), 255}
+	return Effect{Name: "chromakey", Pointwise: true, Apply: func(img *Image, bounds image.Rectangle) {
+		img.ChromaKey(key, p.Tolerance, p.Feather, bounds)
+	}}, nil
+}
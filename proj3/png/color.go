@@ -0,0 +1,59 @@
+package png
+
+import "math"
+
+// rgbToHSV converts r, g, b in [0, 1] to hue in degrees [0, 360), saturation and value in [0, 1]
+func rgbToHSV(r, g, b float64) (float64, float64, float64) {
+	max := math.Max(r, math.Max(g, b))
+	min := math.Min(r, math.Min(g, b))
+	delta := max - min
+
+	var h float64
+	switch {
+	case delta == 0:
+		h = 0
+	case max == r:
+		h = 60 * math.Mod((g-b)/delta, 6)
+	case max == g:
+		h = 60 * ((b-r)/delta + 2)
+	default:
+		h = 60 * ((r-g)/delta + 4)
+	}
+	if h < 0 {
+		h += 360
+	}
+
+	s := 0.0
+	if max > 0 {
+		s = delta / max
+	}
+	return h, s, max
+}
+
+// hsvToRGB converts hue in degrees, saturation and value in [0, 1] back to r, g, b in [0, 1]
+func hsvToRGB(h, s, v float64) (float64, float64, float64) {
+	h = math.Mod(h, 360)
+	if h < 0 {
+		h += 360
+	}
+	c := v * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := v - c
+
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	return r + m, g + m, b + m
+}
@@ -0,0 +1,75 @@
+package png
+
+import (
+	"image"
+	"sync"
+)
+
+// toRGBA64 copies a decoded image into a new RGBA64 buffer, premultiplied, splitting the
+// rows between workers goroutines. The common decoded types are read from their Pix
+// slice, anything else goes through At. The values are the ones At().RGBA() gives.
+func toRGBA64(src image.Image, workers int) *image.RGBA64 {
+	bounds := src.Bounds()
+	dst := image.NewRGBA64(bounds)
+
+	var convert func(y int)
+	switch src := src.(type) {
+	case *image.RGBA64:
+		convert = func(y int) {
+			copyRows(dst, src, image.Rect(bounds.Min.X, y, bounds.Max.X, y+1))
+		}
+	case *image.RGBA:
+		convert = func(y int) {
+			i, j := dst.PixOffset(bounds.Min.X, y), src.PixOffset(bounds.Min.X, y)
+			for x := bounds.Min.X; x < bounds.Max.X; x, i, j = x+1, i+8, j+4 {
+				s := src.Pix[j : j+4 : j+4]
+				setPixel(dst.Pix, i, uint16(s[0])*0x101, uint16(s[1])*0x101, uint16(s[2])*0x101, uint16(s[3])*0x101)
+			}
+		}
+	case *image.NRGBA:
+		convert = func(y int) {
+			i, j := dst.PixOffset(bounds.Min.X, y), src.PixOffset(bounds.Min.X, y)
+			for x := bounds.Min.X; x < bounds.Max.X; x, i, j = x+1, i+8, j+4 {
+				s := src.Pix[j : j+4 : j+4]
+				a := uint32(s[3])
+				// the same rounding as color.NRGBA.RGBA
+				setPixel(dst.Pix, i, uint16(uint32(s[0])*0x101*a/0xff), uint16(uint32(s[1])*0x101*a/0xff),
+					uint16(uint32(s[2])*0x101*a/0xff), uint16(a*0x101))
+			}
+		}
+	case *image.Gray:
+		convert = func(y int) {
+			i, j := dst.PixOffset(bounds.Min.X, y), src.PixOffset(bounds.Min.X, y)
+			for x := bounds.Min.X; x < bounds.Max.X; x, i, j = x+1, i+8, j+1 {
+				v := uint16(src.Pix[j]) * 0x101
+				setPixel(dst.Pix, i, v, v, v, 0xffff)
+			}
+		}
+	default:
+		convert = func(y int) {
+			i := dst.PixOffset(bounds.Min.X, y)
+			for x := bounds.Min.X; x < bounds.Max.X; x, i = x+1, i+8 {
+				r, g, b, a := src.At(x, y).RGBA()
+				setPixel(dst.Pix, i, uint16(r), uint16(g), uint16(b), uint16(a))
+			}
+		}
+	}
+
+	// every goroutine converts a band of rows, like the parslices scheduler
+	height := bounds.Dy()
+	workers = Max(1, Min(workers, height))
+	rows := (height + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := bounds.Min.Y; start < bounds.Max.Y; start += rows {
+		end := Min(start+rows, bounds.Max.Y)
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for y := start; y < end; y++ {
+				convert(y)
+			}
+		}(start, end)
+	}
+	wg.Wait()
+	return dst
+}
@@ -0,0 +1,122 @@
+package png
+
+import (
+	"fmt"
+	"image"
+)
+
+// Depth is the bit depth and color model of a saved image. Save writes the narrowest
+// type holding the result at that depth: gray when every pixel is gray and opaque,
+// color with alpha only when some pixel is not opaque.
+type Depth int
+
+const (
+	DepthPreserve Depth = iota // 16 bits if the source had 16 bits per channel, else 8
+	DepthRGBA8                 // 8 bits per channel
+	DepthRGBA16                // 16 bits per channel, the working precision
+	DepthGray8                 // 8-bit gray, with alpha if the image is not opaque
+	DepthGray16                // 16-bit gray, with alpha if the image is not opaque
+)
+
+var depthNames = map[string]Depth{
+	"preserve": DepthPreserve, "rgba8": DepthRGBA8, "rgba16": DepthRGBA16,
+	"gray8": DepthGray8, "gray16": DepthGray16,
+}
+
+// ParseDepth converts a depth name of effects.txt or the editor into a Depth
+func ParseDepth(name string) (Depth, error) {
+	depth, ok := depthNames[name]
+	if !ok {
+		return DepthPreserve, fmt.Errorf("unknown depth %q, want preserve, rgba8, rgba16, gray8 or gray16", name)
+	}
+	return depth, nil
+}
+
+// sourceBits returns the bits per channel of a decoded image
+func sourceBits(m image.Image) int {
+	switch m.(type) {
+	case *image.RGBA64, *image.NRGBA64, *image.Gray16:
+		return 16
+	}
+	return 8
+}
+
+// scan tells whether every pixel of the buffer is opaque, and whether every one is gray
+func scan(buf *image.RGBA64) (opaque, gray bool) {
+	opaque, gray = true, true
+	for i := 0; i+8 <= len(buf.Pix); i += 8 {
+		r, g, b, a := pixel(buf.Pix, i)
+		opaque = opaque && a == 0xffff
+		gray = gray && r == g && g == b
+		if !opaque && !gray {
+			break
+		}
+	}
+	return opaque, gray
+}
+
+// unpremultiply returns the straight value of a premultiplied channel, like color.NRGBA64Model
+func unpremultiply(v, a uint16) uint16 {
+	if a == 0xffff || a == 0 {
+		return v
+	}
+	return uint16(uint32(v) * 0xffff / uint32(a))
+}
+
+// output converts Out into the image written for the depth
+func (img *Image) output(depth Depth) image.Image {
+	src := img.Out
+	bounds := src.Bounds()
+	bits := 16
+	switch depth {
+	case DepthPreserve:
+		bits = img.Bits
+		if bits == 0 {
+			bits = 16 // not loaded from a file
+		}
+	case DepthRGBA8, DepthGray8:
+		bits = 8
+	}
+	opaque, gray := scan(src)
+	toGray := depth == DepthGray8 || depth == DepthGray16
+	if !toGray && !(gray && opaque) && bits == 16 {
+		return src // the encoders handle the premultiplied buffer at full depth
+	}
+
+	var dst interface {
+		image.Image
+		PixOffset(x, y int) int
+	}
+	var set func(i int, r, g, b, a uint16)
+	switch {
+	case opaque && (gray || toGray) && bits == 8:
+		m := image.NewGray(bounds)
+		dst, set = m, func(i int, r, g, b, a uint16) { m.Pix[i] = uint8(r >> 8) }
+	case opaque && (gray || toGray):
+		m := image.NewGray16(bounds)
+		dst, set = m, func(i int, r, g, b, a uint16) { m.Pix[i], m.Pix[i+1] = uint8(r>>8), uint8(r) }
+	case bits == 8:
+		m := image.NewNRGBA(bounds)
+		dst, set = m, func(i int, r, g, b, a uint16) {
+			s := m.Pix[i : i+4 : i+4]
+			s[0], s[1], s[2], s[3] = uint8(r>>8), uint8(g>>8), uint8(b>>8), uint8(a>>8)
+		}
+	default:
+		m := image.NewNRGBA64(bounds)
+		dst, set = m, func(i int, r, g, b, a uint16) { setPixel(m.Pix, i, r, g, b, a) }
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		i := src.PixOffset(bounds.Min.X, y)
+		for x := bounds.Min.X; x < bounds.Max.X; x, i = x+1, i+8 {
+			r, g, b, a := pixel(src.Pix, i)
+			if toGray {
+				// the same weights as color.GrayModel, on the premultiplied color
+				v := uint16((19595*uint32(r) + 38470*uint32(g) + 7471*uint32(b) + 1<<15) >> 16)
+				r, g, b = v, v, v
+			}
+			set(dst.PixOffset(x, y), unpremultiply(r, a), unpremultiply(g, a), unpremultiply(b, a), a)
+		}
+	}
+	return dst
+}
@@ -5,7 +5,6 @@ package png
 import (
 	// "fmt"
 	"image"
-	"image/color"
 )
 
 // GetBoundary returns either the provided boundaries (specifically the first one if multiple are provided) or the bounds of the image itself.
@@ -20,12 +19,14 @@ func (img *Image) GetBoundary(boundaries ...image.Rectangle) image.Rectangle {
 
 // Grayscale applies a grayscale filtering effect to the image
 func (img *Image) Grayscale(boundaries ...image.Rectangle) {
-	bounds := img.GetBoundary(boundaries...)
-	startX, endX, startY, endY := bounds.Min.X + 1, bounds.Max.X -1 , bounds.Min.Y, bounds.Max.Y
+	img.GrayscaleWith(GrayAverage, boundaries...)
+}
 
-	// Adjustments for the boarders
-	startY = Max(startY, 1)	// startY no lower than 0
-	endY = Min(endY,(img.Bounds.Max.Y-1))	// endY no greater than original height index
+// GrayscaleWith applies a grayscale filtering effect using the given luminance formula
+func (img *Image) GrayscaleWith(method GrayMethod, boundaries ...image.Rectangle) {
+	bounds := img.GetBoundary(boundaries...)
+	// A pixel does not depend on its neighbours, so the frame is done too
+	startX, endX, startY, endY := bounds.Min.X, bounds.Max.X, bounds.Min.Y, bounds.Max.Y
 
 	// Bounds returns defines the dimensions of the image. Always
 	// use the bounds Min and Max fields to get out the width
@@ -34,125 +35,122 @@ func (img *Image) Grayscale(boundaries ...image.Rectangle) {
 	for y := startY; y < endY; y++ {
 		for x := startX; x < endX; x++ {
 			var greyC uint16
-			//Returns the pixel (i.e., RGBA) value at a (x,y) position
-			// Note: These get returned as int32 so based on the math you'll
-			// be performing you'll need to do a conversion to float64(..)
+			//Returns the pixel (i.e., RGBA) value at a (x,y) position, read from the Pix slice
 			// a os alpha : opacity
-			//.RGBA() : Use these values for computations where you need standardized uint32 values,
-			r, g, b, a := img.In.At(x, y).RGBA()
-
-			// early return if pixel is black
-			if checkPixelColor(r, g, b, a) {
-				img.Out.Set(x, y, color.RGBA64{0, 0, 0, 0})
-				continue
-			} else {
-				//Note: The values for r,g,b,a for this assignment will range between [0, 65535].
-				//For certain computations (i.e., convolution) the values might fall outside this
-				// range so you need to clamp them between those values.
-				// Create gray colour is to find 'average' of r g b
-				greyC = clamp(float64(r+g+b) / 3)
-				//Note: The values need to be stored back as uint16 (I know weird..but there's valid reasons
-				// for this that I won't get into right now).
-				img.Out.Set(x, y, color.RGBA64{greyC, greyC, greyC, uint16(a)})
+			r16, g16, b16, a16 := pixel(img.In.Pix, img.In.PixOffset(x, y))
+			r, g, b, a := uint32(r16), uint32(g16), uint32(b16), uint32(a16)
+
+			//Note: The values for r,g,b,a for this assignment will range between [0, 65535].
+			//For certain computations (i.e., convolution) the values might fall outside this
+			// range so you need to clamp them between those values.
+			// Create gray colour from r g b, by default their 'average'
+			// the color stays premultiplied, so it must not exceed the alpha it is kept with
+			greyC = Min16(clamp(method.gray(r, g, b, a)), uint16(a))
+			//Note: The values need to be stored back as uint16 (I know weird..but there's valid reasons
+			// for this that I won't get into right now).
+			setPixel(img.Out.Pix, img.Out.PixOffset(x, y), greyC, greyC, greyC, a16)
 		}
 	}
 }
-}
 
 //General convolution function
 // pixel * kernel = newpixel
 // kernel : https://www.songho.ca/dsp/convolution/convolution2d_example.html
-func (img *Image) applyKernel(kernel []float64, bounds image.Rectangle) {
+func (img *Image) applyKernel(kernel Kernel, bounds image.Rectangle) {
 
-	startX, endX, startY, endY := bounds.Min.X + 1, bounds.Max.X - 1, bounds.Min.Y, bounds.Max.Y	// for bound [0:10] we do [1:9]
+	full := img.Bounds
+	startX, endX, startY, endY := bounds.Min.X, bounds.Max.X, bounds.Min.Y, bounds.Max.Y
+	width, _ := kernel.Size()
+	rx, ry := kernel.Radius()
 
 	// Adjustments for the boarders
-	startY = Max(startY, 1)	// startY no lower than 1
-	endY = Min(endY,(img.Bounds.Max.Y-1))	// endY no greater than original height index
+	// The frame is taken from the full image, not the slice, so slices give the same result as the whole image
+	if kernel.Edge == EdgeSkip {	// for bound [0:10] and radius 1 we do [1:9] and copy 0 and 9
+		inner := innerBounds(full, rx, ry)
+		img.copyFrame(bounds, inner)
+		startX, endX = Max(startX, inner.Min.X), Min(endX, inner.Max.X)
+		startY, endY = Max(startY, inner.Min.Y), Min(endY, inner.Max.Y)
+	}
+
+	divisor := kernel.Divisor
+	if divisor == 0 {
+		divisor = 1
+	}
+	bias := kernel.Bias * 257	// 0-255 scale to 0-65535
+
+	// Alpha is convolved with the weights scaled to sum to 1, so a blur fades the edge of
+	// a transparent region. A kernel summing to 0 (a derivative) keeps the alpha of the pixel.
+	weightSum := sumOf(kernel.Weights)
+
+	// Pixels whose whole window is inside the image read In's Pix directly, row after row.
+	// The others go through the edge mode.
+	inPix, outPix, stride := img.In.Pix, img.Out.Pix, img.In.Stride
 
 	// fmt.Printf("Working Bounds : %d %d %d %d\n", startX, endX, startY, endY)
 	// working on adjusted startY-endY
 	for y := startY; y < endY; y++ {
-			for x := startX; x < endX; x++ {	// width not including 1 pixel on the side
-					var sumR, sumG, sumB, A float64
-					// fmt.Printf("x,y : %d %d \n", x, y)
-
-					r,g,b,a := img.In.At(x, y).RGBA()
-					if checkPixelColor(r,g,b,a) {	// early exit if pixel i black
-						img.Out.Set(x, y, color.RGBA64{0, 0, 0, 0})
-						continue
+		o := img.Out.PixOffset(startX, y)
+		for x := startX; x < endX; x, o = x+1, o+8 {	// width not including the frame on the side
+			var sumR, sumG, sumB, sumA float64
+			inside := x-rx >= full.Min.X && x+rx < full.Max.X && y-ry >= full.Min.Y && y+ry < full.Max.Y
+			i := img.In.PixOffset(x-rx, y-ry)
+
+			k := 0
+			for ky := -ry; ky <= ry; ky++ {
+				for kx := -rx; kx <= rx; kx++ {
+					var r, g, b, a uint16
+					if inside {
+						r, g, b, a = pixel(inPix, i)
+						i += 8
 					} else {
-						for ky := -1; ky <= 1; ky++ {
-								for kx := -1; kx <= 1; kx++ {
-									boundaryClamp := func(val, min, max int) int {
-										if val < min {
-											return min
-											} else if val > max {
-												return max
-											}
-											return val
-										}
-
-										// Neighbours are not exceeding the full image boundary
-										nx := boundaryClamp(x+kx, 0, bounds.Max.X-1)	// from index 0 to width-1
-										ny := boundaryClamp(y+ky, 0, bounds.Max.Y+1)  // slice range
-
-										r, g, b, a := img.In.At(nx, ny).RGBA() // get weight for a pixel by summing all the nb's weight; return uint32
-
-										weight := kernel[(ky+1)*3+(kx+1)]			// get kernel weight from array
-										sumR += weight * float64(r)
-										sumG += weight * float64(g)
-										sumB += weight * float64(b)
-										A = float64(a)
-								}
-						}
-						col := color.RGBA64{			// Ensure within bound
-								R: uint16(clamp(sumR)),
-								G: uint16(clamp(sumG)),
-								B: uint16(clamp(sumB)),
-								A: uint16(A),
-						}
-
-						// Save new pixel
-						img.Out.Set(x, y, col)
-						// fmt.Println(col)
+						// Neighbours outside the full image come from the edge mode
+						c := img.edgePixel(kernel.Edge, kernel.Fill, x+kx, y+ky)
+						r, g, b, a = c.R, c.G, c.B, c.A
 					}
+					fr, fg, fb, fa := kernelInput(r, g, b, a, kernel.Straight)
+
+					weight := kernel.Weights[k]	// get kernel weight from array
+					k++
+					sumR += weight * fr
+					sumG += weight * fg
+					sumB += weight * fb
+					sumA += weight * fa
+				}
+				i += stride - 8*width
 			}
-	}
 
-}
-
-// Apply each kernel effect on pixel
-func (img *Image) Sharpen(boundaries ...image.Rectangle) {
-	kernel := []float64{0, -1, 0, -1, 5, -1, 0, -1, 0}
-	img.applyKernel(kernel, img.GetBoundary(boundaries...))
-}
+			_, _, _, centre := pixel(inPix, img.In.PixOffset(x, y))
+			alpha := float64(centre)
+			if weightSum != 0 {
+				alpha = sumA / weightSum
+			}
+			// Save new pixel, within bound
+			setPixelColor(outPix, o, kernelOutput(sumR/divisor, sumG/divisor, sumB/divisor, alpha, bias, kernel.Straight))
+		}
+	}
 
-func (img *Image) EdgeDetection(boundaries ...image.Rectangle) {
-	kernel := []float64{-1, -1, -1, -1, 8, -1, -1, -1, -1}
-	img.applyKernel(kernel, img.GetBoundary(boundaries...))
 }
 
-func (img *Image) Blur(boundaries ...image.Rectangle) {
-	kernel := []float64{
+// Weights of the built-in kernels
+var (
+	sharpenWeights       = []float64{0, -1, 0, -1, 5, -1, 0, -1, 0}
+	edgeDetectionWeights = []float64{-1, -1, -1, -1, 8, -1, -1, -1, -1}
+	blurWeights          = []float64{
 			1 / 9.0, 1 / 9.0, 1 / 9.0,
 			1 / 9.0, 1 / 9.0, 1 / 9.0,
 			1 / 9.0, 1 / 9.0, 1 / 9.0,
 	}
-	img.applyKernel(kernel, img.GetBoundary(boundaries...))
-}
-
-// --- Utils ----------------
+)
 
-func checkPixelColor(r,g,b,a uint32) bool {
-	// Convert to non-pre-multiplied values and normalize to 0-255 range
-	r, g, b, _ = r/257, g/257, b/257, a/257
+// Apply each kernel effect on pixel, the edge is clamped
+func (img *Image) Sharpen(boundaries ...image.Rectangle) {
+	img.applyKernel(Kernel{Weights: sharpenWeights}, img.GetBoundary(boundaries...))
+}
 
-	if r == 0 && g == 0 && b == 0 {
-		return true
-	} else {
-		return false
-	}
+func (img *Image) EdgeDetection(boundaries ...image.Rectangle) {
+	img.applyKernel(Kernel{Weights: edgeDetectionWeights}, img.GetBoundary(boundaries...))
 }
 
-// --- End Utils ----------------
+func (img *Image) Blur(boundaries ...image.Rectangle) {
+	img.applyKernel(Kernel{Weights: blurWeights}, img.GetBoundary(boundaries...))
+}
@@ -0,0 +1,102 @@
+package png
+
+import (
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp" // registers the WebP decoder, there is no encoder
+)
+
+// Despite the package name, Load decodes every format registered with the image
+// package, detected from the content of the file: PNG, JPEG, GIF, BMP, TIFF and WebP.
+// Save picks the encoder from the extension of the path.
+
+// SaveOptions are the settings of the encoders, the zero value gives their defaults
+type SaveOptions struct {
+	JPEGQuality    int                  // 1-100, 0 means jpeg.DefaultQuality
+	PNGCompression png.CompressionLevel // png.DefaultCompression by default
+	Depth          Depth                // Bit depth and color model, DepthPreserve by default
+}
+
+var pngCompressionNames = map[string]png.CompressionLevel{
+	"default": png.DefaultCompression,
+	"none":    png.NoCompression,
+	"speed":   png.BestSpeed,
+	"best":    png.BestCompression,
+}
+
+// ParsePNGCompression converts a compression name of the editor into a png.CompressionLevel:
+// default, none, speed or best
+func ParsePNGCompression(name string) (png.CompressionLevel, error) {
+	level, ok := pngCompressionNames[name]
+	if !ok {
+		return png.DefaultCompression, fmt.Errorf("unknown png compression %q", name)
+	}
+	return level, nil
+}
+
+// encoder writes an image in one format
+type encoder func(w io.Writer, m image.Image, opts SaveOptions) error
+
+var encoders = map[string]encoder{
+	".png": func(w io.Writer, m image.Image, opts SaveOptions) error {
+		enc := png.Encoder{CompressionLevel: opts.PNGCompression}
+		return enc.Encode(w, m)
+	},
+	".jpg":  encodeJPEG,
+	".jpeg": encodeJPEG,
+	".gif": func(w io.Writer, m image.Image, opts SaveOptions) error {
+		return gif.Encode(w, m, nil)
+	},
+	".bmp": func(w io.Writer, m image.Image, opts SaveOptions) error {
+		return bmp.Encode(w, m)
+	},
+	".tif":  encodeTIFF,
+	".tiff": encodeTIFF,
+}
+
+// encodeJPEG writes a JPEG, which has no alpha: transparent pixels come out black
+func encodeJPEG(w io.Writer, m image.Image, opts SaveOptions) error {
+	quality := opts.JPEGQuality
+	if quality == 0 {
+		quality = jpeg.DefaultQuality
+	}
+	return jpeg.Encode(w, m, &jpeg.Options{Quality: quality})
+}
+
+func encodeTIFF(w io.Writer, m image.Image, opts SaveOptions) error {
+	return tiff.Encode(w, m, &tiff.Options{Compression: tiff.Deflate, Predictor: true})
+}
+
+// CheckSavePath returns an error if Save cannot write the format of the path's extension
+func CheckSavePath(filePath string) error {
+	_, err := encoderFor(filePath)
+	return err
+}
+
+func encoderFor(filePath string) (encoder, error) {
+	ext := strings.ToLower(filepath.Ext(filePath))
+	if enc, ok := encoders[ext]; ok {
+		return enc, nil
+	}
+	if ext == ".webp" {
+		return nil, fmt.Errorf("webp can be read but not written")
+	}
+	return nil, fmt.Errorf("no encoder for the extension %q of %s", ext, filePath)
+}
+
+// CheckSaveOptions returns an error if an option is out of range
+func CheckSaveOptions(opts SaveOptions) error {
+	if opts.JPEGQuality < 0 || opts.JPEGQuality > 100 {
+		return fmt.Errorf("jpeg quality must be in 1..100, got %d", opts.JPEGQuality)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package png
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+)
+
+// MaxSigma bounds the sigma of the Gaussian effects, a kernel 601 pixels wide
+const MaxSigma = 100
+
+// GaussianKernel returns the normalized Gaussian kernel for sigma.
+// The radius is ceil(3*sigma), which keeps 99.7% of the weight.
+func GaussianKernel(sigma float64) SeparableKernel {
+	radius := int(math.Ceil(3 * sigma))
+	weights := make([]float64, 2*radius+1)
+	sum := 0.0
+	for i := -radius; i <= radius; i++ {
+		w := math.Exp(-float64(i*i) / (2 * sigma * sigma))
+		weights[i+radius] = w
+		sum += w
+	}
+	for i := range weights {
+		weights[i] /= sum
+	}
+	return SeparableKernel{Row: weights, Column: weights, Edge: EdgeClamp}
+}
+
+// GaussianBlur applies a Gaussian blur of the given sigma on the image
+func (img *Image) GaussianBlur(sigma float64, boundaries ...image.Rectangle) {
+	img.ConvolveSeparable(GaussianKernel(sigma), boundaries...)
+}
+
+// gaussianParams is the JSON form of the effect ie. {"name": "gaussian", "sigma": 2.5, "edge": "clamp"}
+type gaussianParams struct {
+	Sigma float64 `json:"sigma"`
+	edgeParams
+	alphaParams
+}
+
+// newGaussianEffect is the factory of the "gaussian" effect
+func newGaussianEffect(params json.RawMessage) (Effect, error) {
+	p := gaussianParams{Sigma: 1, edgeParams: edgeParams{Edge: "clamp"}}
+	if params != nil {
+		if err := decodeParams(params, &p); err != nil {
+			return Effect{}, err
+		}
+	}
+	if p.Sigma <= 0 || p.Sigma > MaxSigma {
+		return Effect{}, fmt.Errorf("sigma must be in (0, %d], got %v", MaxSigma, p.Sigma)
+	}
+	edge, fill, err := p.parse()
+	if err != nil {
+		return Effect{}, err
+	}
+
+	straight, err := p.straight()
+	if err != nil {
+		return Effect{}, err
+	}
+
+	kernel := GaussianKernel(p.Sigma)
+	kernel.Edge, kernel.Fill, kernel.Straight = edge, fill, straight
+	rx, _ := kernel.Radius()
+	return Effect{Name: "gaussian", Radius: rx, Passes: kernel.Passes("gaussian")}, nil
+}
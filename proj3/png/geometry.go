@@ -0,0 +1,314 @@
+package png
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// MaxSize bounds the width and height of a resized image
+const MaxSize = 16384
+
+// Sampling is the interpolation used when a transform reads In between pixel centres
+type Sampling int
+
+const (
+	SampleNearest Sampling = iota
+	SampleBilinear
+	SampleBicubic // Catmull-Rom
+	SampleLanczos // Lanczos-3
+)
+
+var samplingNames = map[string]Sampling{
+	"nearest":  SampleNearest,
+	"bilinear": SampleBilinear,
+	"bicubic":  SampleBicubic,
+	"lanczos":  SampleLanczos,
+}
+
+// ParseSampling converts the "sampling" value of effects.txt into a Sampling
+func ParseSampling(name string) (Sampling, error) {
+	sampling, ok := samplingNames[name]
+	if !ok {
+		return SampleNearest, fmt.Errorf("unknown sampling %q", name)
+	}
+	return sampling, nil
+}
+
+// support returns the radius of the interpolation kernel
+func (s Sampling) support() float64 {
+	switch s {
+	case SampleBilinear:
+		return 1
+	case SampleBicubic:
+		return 2
+	case SampleLanczos:
+		return 3
+	}
+	return 0.5
+}
+
+// weight returns the interpolation kernel at distance t
+func (s Sampling) weight(t float64) float64 {
+	t = math.Abs(t)
+	switch s {
+	case SampleBilinear:
+		if t < 1 {
+			return 1 - t
+		}
+	case SampleBicubic:
+		if t < 1 {
+			return 1.5*t*t*t - 2.5*t*t + 1
+		} else if t < 2 {
+			return -0.5*t*t*t + 2.5*t*t - 4*t + 2
+		}
+	case SampleLanczos:
+		if t == 0 {
+			return 1
+		} else if t < 3 {
+			return 3 * math.Sin(math.Pi*t) * math.Sin(math.Pi*t/3) / (math.Pi * math.Pi * t * t)
+		}
+	default:
+		if t <= 0.5 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// sample returns In interpolated at (fx, fy), in pixel coordinates where pixel centres are
+// integers. scaleX and scaleY (>= 1) widen the kernel when shrinking so every input pixel counts.
+// Taps outside the image are clamped to the edge.
+func (img *Image) sample(sampling Sampling, fx, fy, scaleX, scaleY float64) color.RGBA64 {
+	full := img.In.Bounds()
+	if sampling == SampleNearest && scaleX <= 1 && scaleY <= 1 {
+		x := EdgeClamp.index(int(math.Floor(fx+0.5)), full.Min.X, full.Max.X)
+		y := EdgeClamp.index(int(math.Floor(fy+0.5)), full.Min.Y, full.Max.Y)
+		return img.In.RGBA64At(x, y)
+	}
+
+	rx, ry := sampling.support()*scaleX, sampling.support()*scaleY
+	var sumR, sumG, sumB, sumA, sumW float64
+	for ty := int(math.Ceil(fy - ry)); ty <= int(math.Floor(fy+ry)); ty++ {
+		wy := sampling.weight((float64(ty) - fy) / scaleY)
+		if wy == 0 {
+			continue
+		}
+		y := EdgeClamp.index(ty, full.Min.Y, full.Max.Y)
+		for tx := int(math.Ceil(fx - rx)); tx <= int(math.Floor(fx+rx)); tx++ {
+			w := wy * sampling.weight((float64(tx)-fx)/scaleX)
+			if w == 0 {
+				continue
+			}
+			c := img.In.RGBA64At(EdgeClamp.index(tx, full.Min.X, full.Max.X), y)
+			sumR += w * float64(c.R)
+			sumG += w * float64(c.G)
+			sumB += w * float64(c.B)
+			sumA += w * float64(c.A)
+			sumW += w
+		}
+	}
+	if sumW == 0 {
+		return color.RGBA64{}
+	}
+	a := clamp(sumA / sumW)
+	// bicubic and Lanczos overshoot, keep the color premultiplied
+	return color.RGBA64{
+		R: Min16(clamp(sumR/sumW), a),
+		G: Min16(clamp(sumG/sumW), a),
+		B: Min16(clamp(sumB/sumW), a),
+		A: a,
+	}
+}
+
+// Crop copies the rect of In (clipped to the image) into Out, which must be rect's size
+// with its origin at (0, 0)
+func (img *Image) Crop(rect image.Rectangle, boundaries ...image.Rectangle) {
+	bounds := img.GetBoundary(boundaries...)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			img.Out.SetRGBA64(x, y, img.In.RGBA64At(rect.Min.X+x, rect.Min.Y+y))
+		}
+	}
+}
+
+// Flip mirrors the image left to right, or top to bottom when vertical is set
+func (img *Image) Flip(vertical bool, boundaries ...image.Rectangle) {
+	bounds := img.GetBoundary(boundaries...)
+	full := img.In.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			sx, sy := full.Max.X-1-(x-full.Min.X), y
+			if vertical {
+				sx, sy = x, full.Max.Y-1-(y-full.Min.Y)
+			}
+			img.Out.SetRGBA64(x, y, img.In.RGBA64At(sx, sy))
+		}
+	}
+}
+
+// rotatedBounds returns the size of the image rotated by degrees, at the origin
+func rotatedBounds(in image.Rectangle, degrees float64) image.Rectangle {
+	switch math.Mod(math.Mod(degrees, 360)+360, 360) {
+	case 0, 180:
+		return image.Rect(0, 0, in.Dx(), in.Dy())
+	case 90, 270:
+		return image.Rect(0, 0, in.Dy(), in.Dx())
+	}
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	w := math.Abs(float64(in.Dx())*cos) + math.Abs(float64(in.Dy())*sin)
+	h := math.Abs(float64(in.Dx())*sin) + math.Abs(float64(in.Dy())*cos)
+	return image.Rect(0, 0, int(math.Ceil(w-1e-9)), int(math.Ceil(h-1e-9)))
+}
+
+// Rotate turns the image clockwise by degrees. Out must have the rotated bounds, see
+// rotatedBounds. Multiples of 90 move pixels exactly, other angles are sampled and
+// the corners outside the original image are transparent.
+func (img *Image) Rotate(degrees float64, sampling Sampling, boundaries ...image.Rectangle) {
+	bounds := img.GetBoundary(boundaries...)
+	full := img.In.Bounds()
+	w, h := full.Dx(), full.Dy()
+	turn := math.Mod(math.Mod(degrees, 360)+360, 360)
+
+	if turn == 0 || turn == 90 || turn == 180 || turn == 270 {
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				var sx, sy int
+				switch turn {
+				case 0:
+					sx, sy = x, y
+				case 90:
+					sx, sy = y, h-1-x
+				case 180:
+					sx, sy = w-1-x, h-1-y
+				case 270:
+					sx, sy = w-1-y, x
+				}
+				img.Out.SetRGBA64(x, y, img.In.RGBA64At(full.Min.X+sx, full.Min.Y+sy))
+			}
+		}
+		return
+	}
+
+	// map each output pixel centre back into In around the two centres
+	out := img.Out.Bounds()
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	ocx, ocy := float64(out.Dx())/2, float64(out.Dy())/2
+	icx, icy := float64(w)/2, float64(h)/2
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			dx, dy := float64(x)+0.5-ocx, float64(y)+0.5-ocy
+			fx := icx + dx*cos + dy*sin - 0.5
+			fy := icy - dx*sin + dy*cos - 0.5
+			if fx < -0.5 || fy < -0.5 || fx > float64(w)-0.5 || fy > float64(h)-0.5 {
+				img.Out.SetRGBA64(x, y, color.RGBA64{})
+				continue
+			}
+			img.Out.SetRGBA64(x, y, img.sample(sampling, float64(full.Min.X)+fx, float64(full.Min.Y)+fy, 1, 1))
+		}
+	}
+}
+
+// Resize scales In to the size of Out
+func (img *Image) Resize(sampling Sampling, boundaries ...image.Rectangle) {
+	bounds := img.GetBoundary(boundaries...)
+	in, out := img.In.Bounds(), img.Out.Bounds()
+	sx := float64(in.Dx()) / float64(out.Dx())
+	sy := float64(in.Dy()) / float64(out.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		fy := float64(in.Min.Y) + (float64(y-out.Min.Y)+0.5)*sy - 0.5
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			fx := float64(in.Min.X) + (float64(x-out.Min.X)+0.5)*sx - 0.5
+			img.Out.SetRGBA64(x, y, img.sample(sampling, fx, fy, math.Max(1, sx), math.Max(1, sy)))
+		}
+	}
+}
+
+// geometryParams is the JSON form of the transforms ie.
+// {"name": "crop", "x": 10, "y": 10, "width": 200, "height": 100}, {"name": "flip", "axis": "vertical"},
+// {"name": "rotate", "degrees": 30, "sampling": "bilinear"} or {"name": "resize", "width": 640, "sampling": "lanczos"}
+type geometryParams struct {
+	X        int     `json:"x"`
+	Y        int     `json:"y"`
+	Width    int     `json:"width"`
+	Height   int     `json:"height"`
+	Axis     string  `json:"axis"`
+	Degrees  float64 `json:"degrees"`
+	Sampling string  `json:"sampling"`
+}
+
+// geometryFactory returns the factory of the transform called name
+func geometryFactory(name string) EffectFactory {
+	return func(params json.RawMessage) (Effect, error) {
+		p := geometryParams{Axis: "horizontal", Sampling: "bilinear"}
+		if params != nil {
+			if err := decodeParams(params, &p); err != nil {
+				return Effect{}, err
+			}
+		}
+		sampling, err := ParseSampling(p.Sampling)
+		if err != nil {
+			return Effect{}, err
+		}
+		effect := Effect{Name: name}
+
+		switch name {
+		case "crop":
+			if p.Width <= 0 || p.Height <= 0 || p.X < 0 || p.Y < 0 {
+				return Effect{}, fmt.Errorf("crop needs a positive width and height and a non negative x and y")
+			}
+			var rect image.Rectangle
+			effect.OutBounds = func(in image.Rectangle) (image.Rectangle, error) {
+				rect = image.Rect(p.X, p.Y, p.X+p.Width, p.Y+p.Height).Add(in.Min).Intersect(in)
+				if rect.Empty() {
+					return rect, fmt.Errorf("x %d, y %d is outside the %dx%d image", p.X, p.Y, in.Dx(), in.Dy())
+				}
+				return image.Rect(0, 0, rect.Dx(), rect.Dy()), nil
+			}
+			effect.Apply = func(img *Image, bounds image.Rectangle) {
+				img.Crop(rect, bounds)
+			}
+		case "flip":
+			if p.Axis != "horizontal" && p.Axis != "vertical" {
+				return Effect{}, fmt.Errorf("flip axis must be horizontal or vertical, got %q", p.Axis)
+			}
+			effect.Apply = func(img *Image, bounds image.Rectangle) {
+				img.Flip(p.Axis == "vertical", bounds)
+			}
+		case "rotate":
+			effect.OutBounds = func(in image.Rectangle) (image.Rectangle, error) {
+				return rotatedBounds(in, p.Degrees), nil
+			}
+			effect.Apply = func(img *Image, bounds image.Rectangle) {
+				img.Rotate(p.Degrees, sampling, bounds)
+			}
+		case "resize":
+			if p.Width < 0 || p.Height < 0 || p.Width+p.Height == 0 {
+				return Effect{}, fmt.Errorf("resize needs a width or a height")
+			}
+			if p.Width > MaxSize || p.Height > MaxSize {
+				return Effect{}, fmt.Errorf("resize width and height must be at most %d, got %dx%d", MaxSize, p.Width, p.Height)
+			}
+			effect.OutBounds = func(in image.Rectangle) (image.Rectangle, error) {
+				// a missing side keeps the aspect ratio
+				w, h := p.Width, p.Height
+				if w == 0 {
+					w = Max(1, int(math.Round(float64(in.Dx())*float64(h)/float64(in.Dy()))))
+				}
+				if h == 0 {
+					h = Max(1, int(math.Round(float64(in.Dy())*float64(w)/float64(in.Dx()))))
+				}
+				if w > MaxSize || h > MaxSize {
+					return image.Rectangle{}, fmt.Errorf("%dx%d image would be %dx%d, larger than %d", in.Dx(), in.Dy(), w, h, MaxSize)
+				}
+				return image.Rect(0, 0, w, h), nil
+			}
+			effect.Apply = func(img *Image, bounds image.Rectangle) {
+				img.Resize(sampling, bounds)
+			}
+		}
+		return effect, nil
+	}
+}
@@ -0,0 +1,108 @@
+package png
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// GradientOperator is a pair of 3x3 kernels giving the x and y derivatives of the image
+type GradientOperator struct {
+	X []float64
+	Y []float64
+}
+
+var (
+	// Sobel weights the centre row and column twice, which smooths the noise a little
+	Sobel = GradientOperator{
+		X: []float64{-1, 0, 1, -2, 0, 2, -1, 0, 1},
+		Y: []float64{-1, -2, -1, 0, 0, 0, 1, 2, 1},
+	}
+	// Prewitt weights the three rows or columns the same
+	Prewitt = GradientOperator{
+		X: []float64{-1, 0, 1, -1, 0, 1, -1, 0, 1},
+		Y: []float64{-1, -1, -1, 0, 0, 0, 1, 1, 1},
+	}
+)
+
+// luminance of a pixel, the same average as Grayscale
+func luminance(c color.RGBA64) float64 {
+	return (float64(c.R) + float64(c.G) + float64(c.B)) / 3
+}
+
+// gradientAt returns the x and y derivatives of the luminance of In at (x, y).
+// fill is the color outside the image with EdgeConstant.
+func (img *Image) gradientAt(op GradientOperator, edge EdgeMode, fill color.RGBA64, x, y int) (float64, float64) {
+	var gx, gy float64
+	for ky := -1; ky <= 1; ky++ {
+		for kx := -1; kx <= 1; kx++ {
+			l := luminance(img.edgePixel(edge, fill, x+kx, y+ky))
+			gx += op.X[(ky+1)*3+(kx+1)] * l
+			gy += op.Y[(ky+1)*3+(kx+1)] * l
+		}
+	}
+	return gx, gy
+}
+
+// Gradient writes the gradient magnitude of the image as a gray image.
+// With direction set the direction is encoded as the hue and the magnitude as the value,
+// so edges of the same orientation get the same color.
+func (img *Image) Gradient(op GradientOperator, direction bool, edge EdgeMode, fill color.RGBA64, boundaries ...image.Rectangle) {
+	bounds := img.GetBoundary(boundaries...)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			gx, gy := img.gradientAt(op, edge, fill, x, y)
+			magnitude := clamp(math.Hypot(gx, gy))
+			a := img.In.RGBA64At(x, y).A
+
+			if !direction {
+				v := premultiply(magnitude, a) // the buffer is premultiplied
+				img.Out.SetRGBA64(x, y, color.RGBA64{v, v, v, a})
+				continue
+			}
+			angle := math.Atan2(gy, gx) * 180 / math.Pi // -180..180
+			r, g, b := hsvToRGB(angle+180, 1, float64(magnitude)/65535)
+			img.Out.SetRGBA64(x, y, color.RGBA64{premultiply(clamp(r*65535), a), premultiply(clamp(g*65535), a), premultiply(clamp(b*65535), a), a})
+		}
+	}
+}
+
+// Sobel writes the Sobel gradient magnitude of the image
+func (img *Image) Sobel(boundaries ...image.Rectangle) {
+	img.Gradient(Sobel, false, EdgeClamp, color.RGBA64{}, boundaries...)
+}
+
+// Prewitt writes the Prewitt gradient magnitude of the image
+func (img *Image) Prewitt(boundaries ...image.Rectangle) {
+	img.Gradient(Prewitt, false, EdgeClamp, color.RGBA64{}, boundaries...)
+}
+
+// gradientParams is the JSON form of the effects ie. {"name": "sobel", "direction": true}
+type gradientParams struct {
+	Direction bool `json:"direction"`
+	edgeParams
+}
+
+// gradientFactory returns the factory of a gradient effect using op
+func gradientFactory(name string, op GradientOperator) EffectFactory {
+	return func(params json.RawMessage) (Effect, error) {
+		p := gradientParams{edgeParams: edgeParams{Edge: "clamp"}}
+		if params != nil {
+			if err := decodeParams(params, &p); err != nil {
+				return Effect{}, err
+			}
+		}
+		edge, fill, err := p.parse()
+		if err != nil {
+			return Effect{}, err
+		}
+		if edge == EdgeSkip {
+			return Effect{}, fmt.Errorf("edge mode skip is not supported")
+		}
+		return Effect{Name: name, Radius: 1, Apply: func(img *Image, bounds image.Rectangle) {
+			img.Gradient(op, p.Direction, edge, fill, bounds)
+		}}, nil
+	}
+}
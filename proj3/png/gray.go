@@ -0,0 +1,99 @@
+package png
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+)
+
+// GrayMethod is the formula used to turn a color into a gray level
+type GrayMethod int
+
+const (
+	GrayAverage    GrayMethod = iota // (r+g+b)/3, the original Grayscale
+	GrayRec601                       // Luma of Rec. 601 (SD video, JPEG): 0.299 R + 0.587 G + 0.114 B
+	GrayRec709                       // Luma of Rec. 709 (HD video, sRGB): 0.2126 R + 0.7152 G + 0.0722 B
+	GrayLightness                    // CIE L* of the Rec. 709 luminance of the linearized sRGB color
+	GrayDesaturate                   // (max+min)/2, the HSL lightness used by "desaturate" in image editors
+)
+
+var grayMethodNames = map[string]GrayMethod{
+	"average":    GrayAverage,
+	"rec601":     GrayRec601,
+	"rec709":     GrayRec709,
+	"lightness":  GrayLightness,
+	"desaturate": GrayDesaturate,
+}
+
+// ParseGrayMethod converts the "method" value of effects.txt into a GrayMethod
+func ParseGrayMethod(name string) (GrayMethod, error) {
+	method, ok := grayMethodNames[name]
+	if !ok {
+		return GrayAverage, fmt.Errorf("unknown grayscale method %q", name)
+	}
+	return method, nil
+}
+
+// gray returns the gray level of a premultiplied color, on the 0-65535 scale like the input
+func (method GrayMethod) gray(r, g, b, a uint32) float64 {
+	switch method {
+	case GrayRec601:
+		return 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+	case GrayRec709:
+		return 0.2126*float64(r) + 0.7152*float64(g) + 0.0722*float64(b)
+	case GrayLightness:
+		if a == 0 {
+			return 0
+		}
+		// L* is not linear, so work on the straight color and premultiply the result
+		alpha := float64(a)
+		y := 0.2126*srgbToLinear(float64(r)/alpha) + 0.7152*srgbToLinear(float64(g)/alpha) + 0.0722*srgbToLinear(float64(b)/alpha)
+		return cieLightness(y) * alpha
+	case GrayDesaturate:
+		max := math.Max(float64(r), math.Max(float64(g), float64(b)))
+		min := math.Min(float64(r), math.Min(float64(g), float64(b)))
+		return (max + min) / 2
+	default:
+		return float64(r+g+b) / 3
+	}
+}
+
+// srgbToLinear removes the sRGB transfer curve from a value in [0, 1]
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// cieLightness returns L* of the relative luminance y, scaled to [0, 1]
+func cieLightness(y float64) float64 {
+	if y <= 216.0/24389 {
+		return y * 24389 / 27 / 100
+	}
+	return (116*math.Cbrt(y) - 16) / 100
+}
+
+// grayParams is the JSON form of the effect ie. {"name": "G", "method": "rec709"}
+type grayParams struct {
+	Method string `json:"method"`
+}
+
+// newGrayscaleEffect is the factory of the "G" effect, the average method by default
+// so existing effects.txt files give the same output
+func newGrayscaleEffect(params json.RawMessage) (Effect, error) {
+	p := grayParams{Method: "average"}
+	if params != nil {
+		if err := decodeParams(params, &p); err != nil {
+			return Effect{}, err
+		}
+	}
+	method, err := ParseGrayMethod(p.Method)
+	if err != nil {
+		return Effect{}, err
+	}
+	return Effect{Name: "G", Pointwise: true, Apply: func(img *Image, bounds image.Rectangle) {
+		img.GrayscaleWith(method, bounds)
+	}}, nil
+}
@@ -0,0 +1,337 @@
+package png
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Histogram counts the pixels of each 8-bit level, per channel. The levels are
+// taken from the straight (not premultiplied) color and fully transparent pixels are left out.
+type Histogram struct {
+	Red    [256]uint64 `json:"red"`
+	Green  [256]uint64 `json:"green"`
+	Blue   [256]uint64 `json:"blue"`
+	Luma   [256]uint64 `json:"luma"` // Rec. 709 luma
+	Pixels uint64      `json:"pixels"`
+}
+
+// Add merges the counts of other into h
+func (h *Histogram) Add(other *Histogram) {
+	for i := 0; i < 256; i++ {
+		h.Red[i] += other.Red[i]
+		h.Green[i] += other.Green[i]
+		h.Blue[i] += other.Blue[i]
+		h.Luma[i] += other.Luma[i]
+	}
+	h.Pixels += other.Pixels
+}
+
+// straight returns the unpremultiplied color of In at (x, y) on the 0-65535 scale
+func (img *Image) straight(x, y int) (float64, float64, float64, uint16) {
+	c := img.In.RGBA64At(x, y)
+	if c.A == 0 {
+		return 0, 0, 0, 0
+	}
+	scale := 65535 / float64(c.A)
+	return float64(c.R) * scale, float64(c.G) * scale, float64(c.B) * scale, c.A
+}
+
+// luma709 is the Rec. 709 luma of a color on the 0-65535 scale
+func luma709(r, g, b float64) float64 {
+	return 0.2126*r + 0.7152*g + 0.0722*b
+}
+
+// level returns the 8-bit histogram bin of a value on the 0-65535 scale
+func level(v float64) int {
+	return int(clamp(v)) >> 8
+}
+
+// Histogram counts the pixels of In inside the bounds
+func (img *Image) Histogram(boundaries ...image.Rectangle) *Histogram {
+	bounds := img.GetBoundary(boundaries...)
+	h := &Histogram{}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.straight(x, y)
+			if a == 0 {
+				continue
+			}
+			h.Red[level(r)]++
+			h.Green[level(g)]++
+			h.Blue[level(b)]++
+			h.Luma[level(luma709(r, g, b))]++
+			h.Pixels++
+		}
+	}
+	return h
+}
+
+// ToneCurve maps a value on the 0-65535 scale to its equalized value
+type ToneCurve [257]float64
+
+// equalizeCurve builds the curve spreading the counts evenly over the whole range.
+// curve[i] is the cumulative share of the levels below i, values inside a level are interpolated.
+func equalizeCurve(counts *[256]uint64) *ToneCurve {
+	var total, first uint64
+	for _, n := range counts {
+		if first == 0 {
+			first = n // pixels of the darkest level present map to 0
+		}
+		total += n
+	}
+	curve := &ToneCurve{}
+	if total == first {
+		for i := range curve {
+			curve[i] = float64(i) * 256 // a single level, leave the image alone
+		}
+		return curve
+	}
+	var cdf uint64
+	for i := 0; i < 256; i++ {
+		cdf += counts[i]
+		curve[i+1] = math.Max(0, float64(cdf)-float64(first)) / float64(total-first) * 65535
+	}
+	return curve
+}
+
+// Map returns the value v of the 0-65535 scale through the curve
+func (curve *ToneCurve) Map(v float64) float64 {
+	v = math.Max(0, math.Min(65535, v))
+	bin := int(v) >> 8
+	frac := (v - float64(bin<<8)) / 256
+	return curve[bin] + frac*(curve[bin+1]-curve[bin])
+}
+
+// remapLuma sets the luma of a straight color to newLuma keeping its hue, and premultiplies it
+func remapLuma(r, g, b float64, a uint16, newLuma float64) color.RGBA64 {
+	luma := luma709(r, g, b)
+	if luma <= 0 {
+		r, g, b = newLuma, newLuma, newLuma
+	} else {
+		scale := newLuma / luma
+		r, g, b = r*scale, g*scale, b*scale
+	}
+	return color.RGBA64{premultiply(clamp(r), a), premultiply(clamp(g), a), premultiply(clamp(b), a), a}
+}
+
+// equalizer holds the curves of one equalize effect, built after the histogram pass
+type equalizer struct {
+	perChannel bool
+	red        *ToneCurve
+	green      *ToneCurve
+	blue       *ToneCurve
+	luma       *ToneCurve
+}
+
+func (eq *equalizer) merge(partials []interface{}) {
+	h := &Histogram{}
+	for _, partial := range partials {
+		h.Add(partial.(*Histogram))
+	}
+	eq.red, eq.green, eq.blue = equalizeCurve(&h.Red), equalizeCurve(&h.Green), equalizeCurve(&h.Blue)
+	eq.luma = equalizeCurve(&h.Luma)
+}
+
+func (eq *equalizer) remap(img *Image, bounds image.Rectangle) {
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.straight(x, y)
+			if eq.perChannel {
+				img.Out.SetRGBA64(x, y, color.RGBA64{
+					R: premultiply(clamp(eq.red.Map(r)), a),
+					G: premultiply(clamp(eq.green.Map(g)), a),
+					B: premultiply(clamp(eq.blue.Map(b)), a),
+					A: a,
+				})
+				continue
+			}
+			img.Out.SetRGBA64(x, y, remapLuma(r, g, b, a, eq.luma.Map(luma709(r, g, b))))
+		}
+	}
+}
+
+// NewEqualize returns global histogram equalization as two supersteps: a reduction
+// where every slice counts its own histogram, merged into the curves after the barrier,
+// then a pointwise remap. By default the luma is equalized and the hue kept; perChannel
+// equalizes red, green and blue on their own.
+func NewEqualize(perChannel bool) Effect {
+	eq := &equalizer{perChannel: perChannel}
+	return Effect{Name: "equalize", Passes: []Effect{
+		{Name: "equalize/histogram", NoSwap: true,
+			Reduce: func(img *Image, bounds image.Rectangle) interface{} {
+				return img.Histogram(bounds)
+			},
+			Merge: eq.merge,
+		},
+		{Name: "equalize/remap", Pointwise: true, Apply: eq.remap},
+	}}
+}
+
+// clahe holds the tile curves of one CLAHE effect
+type clahe struct {
+	tiles  int     // Tiles across and down
+	clip   float64 // Clip limit, as a multiple of the mean count of a level
+	curves []*ToneCurve
+}
+
+// tileCounts are the luma counts of each tile, tiles*tiles of them row by row
+type tileCounts [][256]uint64
+
+// tileRows are the counts of the rows of tiles a slice covers, from firstRow down
+type tileRows struct {
+	firstRow int
+	counts   tileCounts
+}
+
+// tileOf returns the tile index along one axis of size n for coordinate v
+func (c *clahe) tileOf(v, n int) int {
+	return Min(c.tiles-1, v*c.tiles/n)
+}
+
+// count returns the histograms of the tiles under bounds, only the rows of tiles it
+// covers so that a thin slice does not hold the whole grid
+func (c *clahe) count(img *Image, bounds image.Rectangle) interface{} {
+	full := img.Bounds
+	if bounds.Empty() {
+		return nil
+	}
+	first := c.tileOf(bounds.Min.Y-full.Min.Y, full.Dy())
+	last := c.tileOf(bounds.Max.Y-1-full.Min.Y, full.Dy())
+	rows := tileRows{firstRow: first, counts: make(tileCounts, (last-first+1)*c.tiles)}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		ty := c.tileOf(y-full.Min.Y, full.Dy()) - first
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.straight(x, y)
+			if a == 0 {
+				continue
+			}
+			rows.counts[ty*c.tiles+c.tileOf(x-full.Min.X, full.Dx())][level(luma709(r, g, b))]++
+		}
+	}
+	return rows
+}
+
+func (c *clahe) merge(partials []interface{}) {
+	counts := make(tileCounts, c.tiles*c.tiles)
+	for _, partial := range partials {
+		rows := partial.(tileRows)
+		for t, tile := range rows.counts {
+			for i, n := range tile {
+				counts[rows.firstRow*c.tiles+t][i] += n
+			}
+		}
+	}
+
+	c.curves = make([]*ToneCurve, len(counts))
+	for t := range counts {
+		tile := &counts[t]
+		// clip the peaks and hand the excess out evenly to limit the contrast gain
+		var total uint64
+		for _, n := range tile {
+			total += n
+		}
+		limit := uint64(math.Max(1, c.clip*float64(total)/256))
+		var excess uint64
+		for i, n := range tile {
+			if n > limit {
+				excess += n - limit
+				tile[i] = limit
+			}
+		}
+		for i := range tile {
+			tile[i] += excess / 256
+			if uint64(i) < excess%256 {
+				tile[i]++
+			}
+		}
+
+		// unlike the global curve the darkest level is not pinned to 0, so flat tiles stay flat
+		curve := &ToneCurve{}
+		var cdf uint64
+		for i := 0; i < 256; i++ {
+			cdf += tile[i]
+			if total > 0 {
+				curve[i+1] = float64(cdf) / float64(total) * 65535
+			} else {
+				curve[i+1] = float64(i+1) * 256
+			}
+		}
+		c.curves[t] = curve
+	}
+}
+
+// remap blends the curves of the four tiles around each pixel by their distance to the tile centres
+func (c *clahe) remap(img *Image, bounds image.Rectangle) {
+	full := img.Bounds
+	tileW := float64(full.Dx()) / float64(c.tiles)
+	tileH := float64(full.Dy()) / float64(c.tiles)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		fy := math.Max(0, math.Min(float64(c.tiles-1), (float64(y-full.Min.Y)+0.5)/tileH-0.5))
+		ty0 := int(fy)
+		ty1 := Min(ty0+1, c.tiles-1)
+		wy := fy - float64(ty0)
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			fx := math.Max(0, math.Min(float64(c.tiles-1), (float64(x-full.Min.X)+0.5)/tileW-0.5))
+			tx0 := int(fx)
+			tx1 := Min(tx0+1, c.tiles-1)
+			wx := fx - float64(tx0)
+
+			r, g, b, a := img.straight(x, y)
+			l := luma709(r, g, b)
+			top := (1-wx)*c.curves[ty0*c.tiles+tx0].Map(l) + wx*c.curves[ty0*c.tiles+tx1].Map(l)
+			bottom := (1-wx)*c.curves[ty1*c.tiles+tx0].Map(l) + wx*c.curves[ty1*c.tiles+tx1].Map(l)
+			img.Out.SetRGBA64(x, y, remapLuma(r, g, b, a, (1-wy)*top+wy*bottom))
+		}
+	}
+}
+
+// MaxTiles bounds the CLAHE grid, whose tiles x tiles histograms take 8 MiB at 64
+const MaxTiles = 64
+
+// NewCLAHE returns contrast limited adaptive histogram equalization of the luma over a
+// tiles x tiles grid, as a reduction of the tile histograms followed by a pointwise remap.
+// clip limits each level to clip times the mean count before equalizing a tile.
+func NewCLAHE(tiles int, clip float64) Effect {
+	c := &clahe{tiles: tiles, clip: clip}
+	return Effect{Name: "clahe", Passes: []Effect{
+		{Name: "clahe/histogram", NoSwap: true, Reduce: c.count, Merge: c.merge},
+		{Name: "clahe/remap", Pointwise: true, Apply: c.remap},
+	}}
+}
+
+// equalizeParams is the JSON form of the effects ie. {"name": "equalize", "channels": "rgb"}
+// or {"name": "clahe", "tiles": 8, "clip": 2}
+type equalizeParams struct {
+	Channels string  `json:"channels"`
+	Tiles    int     `json:"tiles"`
+	Clip     float64 `json:"clip"`
+}
+
+func newEqualizeEffect(params json.RawMessage) (Effect, error) {
+	p := equalizeParams{Channels: "luma"}
+	if params != nil {
+		if err := decodeParams(params, &p); err != nil {
+			return Effect{}, err
+		}
+	}
+	if p.Channels != "luma" && p.Channels != "rgb" {
+		return Effect{}, fmt.Errorf("channels must be luma or rgb, got %q", p.Channels)
+	}
+	return NewEqualize(p.Channels == "rgb"), nil
+}
+
+func newCLAHEEffect(params json.RawMessage) (Effect, error) {
+	p := equalizeParams{Tiles: 8, Clip: 2}
+	if params != nil {
+		if err := decodeParams(params, &p); err != nil {
+			return Effect{}, err
+		}
+	}
+	if p.Tiles < 1 || p.Tiles > MaxTiles || p.Clip < 1 {
+		return Effect{}, fmt.Errorf("need 1 <= tiles <= %d and clip >= 1, got %d and %v", MaxTiles, p.Tiles, p.Clip)
+	}
+	return NewCLAHE(p.Tiles, p.Clip), nil
+}
@@ -0,0 +1,298 @@
+package png
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// EdgeMode says how a kernel handles pixels whose neighbours fall outside the image.
+// The edge is always the one of the full image, never the one of a slice, so every
+// scheduler gives the same result.
+type EdgeMode int
+
+const (
+	EdgeClamp    EdgeMode = iota // Replicate the nearest pixel on the edge, the default
+	EdgeReflect                  // Mirror the image about the edge pixel: dcb|abcd|cba
+	EdgeWrap                     // Read the neighbours from the opposite side of the image
+	EdgeConstant                 // Read a constant color outside the image, see Kernel.Fill
+	EdgeSkip                     // Copy the frame the kernel cannot cover (its radius wide) unfiltered
+)
+
+var edgeModeNames = map[string]EdgeMode{
+	"clamp": EdgeClamp, "replicate": EdgeClamp, "reflect": EdgeReflect,
+	"wrap": EdgeWrap, "constant": EdgeConstant, "skip": EdgeSkip,
+}
+
+// ParseEdgeMode converts the "edge" value of effects.txt into an EdgeMode
+func ParseEdgeMode(name string) (EdgeMode, error) {
+	mode, ok := edgeModeNames[name]
+	if !ok {
+		return EdgeClamp, fmt.Errorf("unknown edge mode %q", name)
+	}
+	return mode, nil
+}
+
+// index maps a neighbour coordinate v into [min, max) according to the edge mode.
+// EdgeConstant has no pixel there and clamps, callers read the fill color instead.
+func (mode EdgeMode) index(v, min, max int) int {
+	if v >= min && v < max {
+		return v
+	}
+	switch mode {
+	case EdgeWrap:
+		size := max - min
+		return min + ((v-min)%size+size)%size
+	case EdgeReflect:
+		period := 2 * (max - min - 1)
+		if period == 0 {
+			return min
+		}
+		v = ((v-min)%period + period) % period
+		if v >= max-min {
+			v = period - v
+		}
+		return min + v
+	default: // EdgeClamp, EdgeConstant, and EdgeSkip never reaches outside
+		return Max(min, Min(v, max-1))
+	}
+}
+
+// edgePixel returns the pixel of In at (x, y), or the one the edge mode gives outside the image
+func (img *Image) edgePixel(mode EdgeMode, fill color.RGBA64, x, y int) color.RGBA64 {
+	full := img.Bounds
+	if mode == EdgeConstant && !(image.Point{x, y}).In(full) {
+		return fill
+	}
+	return img.In.RGBA64At(mode.index(x, full.Min.X, full.Max.X), mode.index(y, full.Min.Y, full.Max.Y))
+}
+
+// innerBounds returns the part of the image a kernel of radius rx, ry covers in skip mode.
+// It is empty when the kernel is wider or taller than the image, which is then all frame.
+func innerBounds(full image.Rectangle, rx, ry int) image.Rectangle {
+	if 2*rx >= full.Dx() || 2*ry >= full.Dy() {
+		return image.Rectangle{Min: full.Min, Max: full.Min}
+	}
+	return image.Rect(full.Min.X+rx, full.Min.Y+ry, full.Max.X-rx, full.Max.Y-ry)
+}
+
+// copyFrame copies the pixels of bounds outside inner from In to Out, the frame skip mode leaves unfiltered
+func (img *Image) copyFrame(bounds, inner image.Rectangle) {
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if !(image.Point{x, y}).In(inner) {
+				img.Out.SetRGBA64(x, y, img.In.RGBA64At(x, y))
+			}
+		}
+	}
+}
+
+// sumOf returns the sum of the weights, 0 when it is too small to divide by
+func sumOf(weights []float64) float64 {
+	sum := 0.0
+	for _, w := range weights {
+		sum += w
+	}
+	if math.Abs(sum) < 1e-9 {
+		return 0
+	}
+	return sum
+}
+
+// kernelInput returns the channels a kernel sums for a pixel, premultiplied or straight
+func kernelInput(r, g, b, a uint16, straight bool) (float64, float64, float64, float64) {
+	fr, fg, fb, fa := float64(r), float64(g), float64(b), float64(a)
+	if straight && a > 0 {
+		scale := 65535 / fa
+		fr, fg, fb = fr*scale, fg*scale, fb*scale
+	}
+	return fr, fg, fb, fa
+}
+
+// kernelOutput builds the premultiplied result of a kernel from its divided sums and alpha.
+// The bias is a straight color, so it is premultiplied too, and each color channel is kept
+// below the alpha to stay a valid premultiplied color.
+func kernelOutput(r, g, b, a, bias float64, straight bool) color.RGBA64 {
+	alpha := clamp(math.Round(a)) // an opaque neighbourhood must stay opaque despite the rounding of the weights
+	if straight {
+		return color.RGBA64{premultiply(clamp(r+bias), alpha), premultiply(clamp(g+bias), alpha), premultiply(clamp(b+bias), alpha), alpha}
+	}
+	bias = bias * float64(alpha) / 65535
+	return color.RGBA64{Min16(clamp(r+bias), alpha), Min16(clamp(g+bias), alpha), Min16(clamp(b+bias), alpha), alpha}
+}
+
+// edgeParams are the edge fields shared by the convolution effects of effects.txt ie.
+// {"edge": "constant", "color": [255, 255, 255, 255]}. The color is on the 0-255 scale and
+// not premultiplied, it defaults to transparent black.
+type edgeParams struct {
+	Edge  string    `json:"edge"`
+	Color []float64 `json:"color"`
+}
+
+// parse returns the edge mode and its premultiplied fill color
+func (p edgeParams) parse() (EdgeMode, color.RGBA64, error) {
+	mode, err := ParseEdgeMode(p.Edge)
+	if err != nil {
+		return mode, color.RGBA64{}, err
+	}
+	if p.Color == nil {
+		return mode, color.RGBA64{}, nil
+	}
+	if len(p.Color) != 3 && len(p.Color) != 4 {
+		return mode, color.RGBA64{}, fmt.Errorf("color needs 3 or 4 values, got %d", len(p.Color))
+	}
+	c := append(append([]float64(nil), p.Color...), 255)
+	a := clamp(c[3] * 257)
+	return mode, color.RGBA64{
+		R: premultiply(clamp(c[0]*257), a),
+		G: premultiply(clamp(c[1]*257), a),
+		B: premultiply(clamp(c[2]*257), a),
+		A: a,
+	}, nil
+}
+
+// Kernel is a convolution kernel of odd width and height, ie. 3x3, 5x5 or 7x3
+type Kernel struct {
+	Weights []float64 // Width*Height weights row by row
+	Width   int       // 0 means a square kernel sized from len(Weights)
+	Height  int
+	Divisor float64 // The weighted sum is divided by the divisor. 0 means 1
+	Bias    float64 // Added to each channel after dividing, on the 0-255 scale
+	Edge    EdgeMode
+	Fill    color.RGBA64 // Premultiplied color outside the image with EdgeConstant
+	// Straight convolves the unpremultiplied color and premultiplies the result, for
+	// images whose transparent pixels still hold a meaningful color. By default the
+	// premultiplied color is convolved, so transparent pixels do not bleed into the result.
+	Straight bool
+}
+
+// Size returns the width and height of the kernel
+func (k Kernel) Size() (int, int) {
+	if k.Width == 0 || k.Height == 0 {
+		n := int(math.Sqrt(float64(len(k.Weights))))
+		return n, n
+	}
+	return k.Width, k.Height
+}
+
+// Radius returns how many neighbours the kernel reads on each side of a pixel, in x and y
+func (k Kernel) Radius() (int, int) {
+	w, h := k.Size()
+	return w / 2, h / 2
+}
+
+// Convolve applies a user defined kernel on the image
+func (img *Image) Convolve(kernel Kernel, boundaries ...image.Rectangle) {
+	img.applyKernel(kernel, img.GetBoundary(boundaries...))
+}
+
+// KernelEffect returns the effect applying the kernel.
+// A separable kernel runs as two 1D passes, see SeparableKernel.
+func KernelEffect(name string, kernel Kernel) Effect {
+	rx, ry := kernel.Radius()
+	if sep, ok := kernel.Separable(); ok && rx+ry > 0 {
+		return Effect{Name: name, Radius: Max(rx, ry), Passes: sep.Passes(name)}
+	}
+	return Effect{Name: name, Radius: Max(rx, ry), Apply: func(img *Image, bounds image.Rectangle) {
+		img.Convolve(kernel, bounds)
+	}}
+}
+
+// kernelParams is the JSON form of a kernel in effects.txt ie.
+// {"kernel": [[-2,-1,0],[-1,1,1],[0,1,2]], "divisor": 1, "bias": 128, "edge": "clamp"}
+// or, for a separable kernel, {"name": "kernel", "row": [1,2,1], "column": [1,2,1], "divisor": 16}
+type kernelParams struct {
+	Kernel  [][]float64 `json:"kernel"`
+	Row     []float64   `json:"row"`
+	Column  []float64   `json:"column"`
+	Divisor float64     `json:"divisor"`
+	Bias    float64     `json:"bias"`
+	edgeParams
+	alphaParams
+}
+
+// newKernelEffect is the factory of the "kernel" effect
+func newKernelEffect(params json.RawMessage) (Effect, error) {
+	if params == nil {
+		return Effect{}, fmt.Errorf("missing kernel weights")
+	}
+	p := kernelParams{edgeParams: edgeParams{Edge: "clamp"}}
+	if err := decodeParams(params, &p); err != nil {
+		return Effect{}, err
+	}
+	edge, fill, err := p.parse()
+	if err != nil {
+		return Effect{}, err
+	}
+	straight, err := p.straight()
+	if err != nil {
+		return Effect{}, err
+	}
+
+	if p.Kernel == nil {
+		sep := SeparableKernel{Row: p.Row, Column: p.Column, Divisor: p.Divisor, Bias: p.Bias, Edge: edge, Fill: fill, Straight: straight}
+		if len(sep.Row)%2 == 0 || len(sep.Column)%2 == 0 {
+			return Effect{}, fmt.Errorf("kernel needs either weights or an odd length row and column, got %d and %d", len(sep.Row), len(sep.Column))
+		}
+		rx, ry := sep.Radius()
+		return Effect{Name: "kernel", Radius: Max(rx, ry), Passes: sep.Passes("kernel")}, nil
+	}
+
+	kernel := Kernel{Height: len(p.Kernel), Divisor: p.Divisor, Bias: p.Bias, Edge: edge, Fill: fill, Straight: straight}
+	if kernel.Height%2 == 0 {
+		return Effect{}, fmt.Errorf("kernel needs an odd number of rows, got %d", kernel.Height)
+	}
+	kernel.Width = len(p.Kernel[0])
+	for _, row := range p.Kernel {
+		if len(row) != kernel.Width || len(row)%2 == 0 {
+			return Effect{}, fmt.Errorf("kernel rows must all have the same odd length, got %d and %d", kernel.Width, len(row))
+		}
+		kernel.Weights = append(kernel.Weights, row...)
+	}
+	return KernelEffect("kernel", kernel), nil
+}
+
+// alphaParams is the alpha field of the convolution effects ie. {"alpha": "straight"},
+// "premultiplied" by default
+type alphaParams struct {
+	Alpha string `json:"alpha"`
+}
+
+// straight tells whether the kernel convolves the straight color, see Kernel.Straight
+func (p alphaParams) straight() (bool, error) {
+	switch p.Alpha {
+	case "", "premultiplied":
+		return false, nil
+	case "straight":
+		return true, nil
+	}
+	return false, fmt.Errorf("alpha must be premultiplied or straight, got %q", p.Alpha)
+}
+
+// builtinKernelFactory returns the factory of one of the 3x3 built-in kernels, which
+// takes the edge and alpha fields ie. {"name": "B", "edge": "reflect", "alpha": "straight"}.
+// The box blur is separable and runs as two 1D passes.
+func builtinKernelFactory(name string, weights []float64) EffectFactory {
+	return func(params json.RawMessage) (Effect, error) {
+		p := struct {
+			edgeParams
+			alphaParams
+		}{edgeParams: edgeParams{Edge: "clamp"}}
+		if params != nil {
+			if err := decodeParams(params, &p); err != nil {
+				return Effect{}, err
+			}
+		}
+		edge, fill, err := p.parse()
+		if err != nil {
+			return Effect{}, err
+		}
+		straight, err := p.straight()
+		if err != nil {
+			return Effect{}, err
+		}
+		return KernelEffect(name, Kernel{Weights: weights, Edge: edge, Fill: fill, Straight: straight}), nil
+	}
+}
@@ -0,0 +1,136 @@
+package png
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// testImage returns a w x h image with varied premultiplied colors in In
+func testImage(w, h int) *Image {
+	bounds := image.Rect(0, 0, w, h)
+	img := &Image{In: image.NewRGBA64(bounds), Out: image.NewRGBA64(bounds), Bounds: bounds}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			a := uint16(65535 - 9000*((x+2*y)%4))
+			v := uint16((x*7919 + y*104729) % 65536)
+			img.In.SetRGBA64(x, y, color.RGBA64{premultiply(v, a), premultiply(65535-v, a), premultiply(v/2, a), a})
+		}
+	}
+	return img
+}
+
+// referenceKernel convolves every pixel through the edge mode, without the fast path
+// reading Pix, and returns the Pix it should give
+func referenceKernel(img *Image, kernel Kernel) []uint8 {
+	full := img.Bounds
+	out := image.NewRGBA64(full)
+	rx, ry := kernel.Radius()
+	divisor := kernel.Divisor
+	if divisor == 0 {
+		divisor = 1
+	}
+	weightSum := sumOf(kernel.Weights)
+	for y := full.Min.Y; y < full.Max.Y; y++ {
+		for x := full.Min.X; x < full.Max.X; x++ {
+			covered := x-rx >= full.Min.X && x+rx < full.Max.X && y-ry >= full.Min.Y && y+ry < full.Max.Y
+			if kernel.Edge == EdgeSkip && !covered {
+				out.SetRGBA64(x, y, img.In.RGBA64At(x, y))
+				continue
+			}
+			var sumR, sumG, sumB, sumA float64
+			k := 0
+			for ky := -ry; ky <= ry; ky++ {
+				for kx := -rx; kx <= rx; kx++ {
+					c := img.edgePixel(kernel.Edge, kernel.Fill, x+kx, y+ky)
+					fr, fg, fb, fa := kernelInput(c.R, c.G, c.B, c.A, kernel.Straight)
+					w := kernel.Weights[k]
+					k++
+					sumR, sumG, sumB, sumA = sumR+w*fr, sumG+w*fg, sumB+w*fb, sumA+w*fa
+				}
+			}
+			alpha := float64(img.In.RGBA64At(x, y).A)
+			if weightSum != 0 {
+				alpha = sumA / weightSum
+			}
+			out.SetRGBA64(x, y, kernelOutput(sumR/divisor, sumG/divisor, sumB/divisor, alpha, kernel.Bias*257, kernel.Straight))
+		}
+	}
+	return out.Pix
+}
+
+// Kernels wider or taller than the image must not read Pix outside the image
+func TestKernelOnSmallImages(t *testing.T) {
+	box7 := make([]float64, 49)
+	for i := range box7 {
+		box7[i] = 1
+	}
+	kernels := map[string]Kernel{
+		"sharpen":   {Weights: sharpenWeights},
+		"edge":      {Weights: edgeDetectionWeights, Edge: EdgeReflect},
+		"blur":      {Weights: blurWeights, Edge: EdgeWrap},
+		"7x7":       {Weights: box7, Divisor: 49, Edge: EdgeConstant, Fill: color.RGBA64{65535, 0, 0, 65535}},
+		"7x7 wrap":  {Weights: box7, Divisor: 49, Edge: EdgeWrap},
+		"7x7 skip":  {Weights: box7, Divisor: 49, Edge: EdgeSkip},
+		"edge skip": {Weights: edgeDetectionWeights, Edge: EdgeSkip},
+	}
+	for _, size := range []image.Point{{1, 1}, {1, 5}, {5, 1}, {2, 2}, {4, 4}, {9, 8}} {
+		for name, kernel := range kernels {
+			img := testImage(size.X, size.Y)
+			want := referenceKernel(img, kernel)
+			img.Convolve(kernel)
+			if !bytes.Equal(img.Out.Pix, want) {
+				t.Errorf("%s on %dx%d: result differs from the per pixel edge mode", name, size.X, size.Y)
+			}
+		}
+	}
+}
+
+// runEffect applies the supersteps of the registered effect on the whole image and
+// returns the image, the result in In
+func runEffect(t *testing.T, img *Image, spec string) *Image {
+	var header struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal([]byte(spec), &header); err != nil {
+		t.Fatal(err)
+	}
+	effect, err := NewEffect(header.Name, json.RawMessage(spec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range effect.Supersteps() {
+		bounds, err := img.Prepare(step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if step.Reduce != nil {
+			step.Merge([]interface{}{step.Reduce(img, bounds)})
+		} else {
+			step.Apply(img, bounds)
+		}
+		if !step.NoSwap {
+			img.Swap()
+		}
+	}
+	return img
+}
+
+// With edge skip an image narrower or shorter than the kernel is all frame, copied unfiltered
+func TestSkipCopiesImagesSmallerThanTheKernel(t *testing.T) {
+	for _, size := range []image.Point{{1, 1}, {1, 5}, {5, 1}, {2, 2}} {
+		for _, spec := range []string{
+			`{"name": "B", "edge": "skip"}`,
+			`{"name": "kernel", "kernel": [[1,2,1],[2,4,2],[1,0,1]], "edge": "skip"}`,
+			`{"name": "gaussian", "sigma": 2, "edge": "skip"}`,
+		} {
+			img := testImage(size.X, size.Y)
+			want := append([]uint8(nil), img.In.Pix...)
+			if got := runEffect(t, img, spec).In.Pix; !bytes.Equal(got, want) {
+				t.Errorf("%s on %dx%d: the image was not copied unchanged", spec, size.X, size.Y)
+			}
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package png
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"io"
+)
+
+// Chunk is an ancillary chunk of a PNG file, kept as it was read
+type Chunk struct {
+	Type string // ie. "gAMA"
+	Data []byte
+}
+
+// Metadata holds the chunks of a PNG that the image/png decoder drops: gamma, color
+// profile, physical pixel size and text. Load fills it from a PNG input and Save writes
+// it back into a PNG output. Other formats neither provide nor keep it.
+type Metadata struct {
+	Chunks []Chunk
+}
+
+// keptChunks are the ancillary chunks Load keeps
+var keptChunks = map[string]bool{
+	"gAMA": true, "cHRM": true, "iCCP": true, "sRGB": true, "pHYs": true,
+	"tEXt": true, "iTXt": true, "zTXt": true,
+}
+
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// readMetadata collects the kept chunks of a PNG file
+func readMetadata(file []byte) Metadata {
+	var meta Metadata
+	if !bytes.HasPrefix(file, []byte(pngSignature)) {
+		return meta
+	}
+	for p := len(pngSignature); p+12 <= len(file); {
+		length := int(binary.BigEndian.Uint32(file[p:]))
+		if length < 0 || p+12+length > len(file) {
+			break // truncated, the decoder has already accepted what it needed
+		}
+		kind := string(file[p+4 : p+8])
+		if keptChunks[kind] {
+			meta.Chunks = append(meta.Chunks, Chunk{Type: kind, Data: append([]byte(nil), file[p+8:p+8+length]...)})
+		}
+		if kind == "IEND" {
+			break
+		}
+		p += 12 + length
+	}
+	return meta
+}
+
+// isText tells whether a chunk type holds a keyword and a text
+func isText(kind string) bool {
+	return kind == "tEXt" || kind == "iTXt" || kind == "zTXt"
+}
+
+// Text returns the text stored under the keyword and whether there is one.
+// zTXt chunks are not decompressed and read as missing.
+func (meta *Metadata) Text(keyword string) (string, bool) {
+	for _, chunk := range meta.Chunks {
+		key, value, ok := bytes.Cut(chunk.Data, []byte{0})
+		if !ok || latin1ToString(key) != keyword {
+			continue
+		}
+		switch chunk.Type {
+		case "tEXt":
+			return latin1ToString(value), true
+		case "iTXt":
+			// compression flag and method, then language and translated keyword, both ending in 0
+			if len(value) < 2 || value[0] != 0 {
+				continue
+			}
+			parts := bytes.SplitN(value[2:], []byte{0}, 3)
+			if len(parts) == 3 {
+				return string(parts[2]), true
+			}
+		}
+	}
+	return "", false
+}
+
+// SetText stores text under the keyword, replacing any text already there. ASCII text
+// goes in a tEXt chunk, anything else in an uncompressed UTF-8 iTXt chunk.
+// The keyword must pass CheckKeyword.
+func (meta *Metadata) SetText(keyword, text string) {
+	chunks := meta.Chunks[:0]
+	for _, chunk := range meta.Chunks {
+		if key, _, _ := bytes.Cut(chunk.Data, []byte{0}); isText(chunk.Type) && latin1ToString(key) == keyword {
+			continue
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	key, _ := stringToLatin1(keyword)
+	data := append(key, 0)
+	kind := "tEXt"
+	for i := 0; i < len(text); i++ {
+		if text[i] >= 0x80 {
+			kind = "iTXt"
+			data = append(data, 0, 0, 0, 0) // not compressed, no language, no translated keyword
+			break
+		}
+	}
+	meta.Chunks = append(chunks, Chunk{Type: kind, Data: append(data, text...)})
+}
+
+// CheckKeyword returns an error if keyword cannot name a PNG text chunk: it needs 1 to 79
+// printable Latin-1 characters, without leading, trailing or consecutive spaces.
+func CheckKeyword(keyword string) error {
+	key, ok := stringToLatin1(keyword)
+	if !ok {
+		return fmt.Errorf("text keyword %q is not Latin-1", keyword)
+	}
+	if len(key) < 1 || len(key) > 79 {
+		return fmt.Errorf("text keyword %q must be 1 to 79 characters long", keyword)
+	}
+	for i, c := range key {
+		if c < 32 || (c > 126 && c < 161) {
+			return fmt.Errorf("text keyword %q has a control character", keyword)
+		}
+		if c == ' ' && (i == 0 || i == len(key)-1 || key[i-1] == ' ') {
+			return fmt.Errorf("text keyword %q has a leading, trailing or double space", keyword)
+		}
+	}
+	return nil
+}
+
+// stringToLatin1 returns s in Latin-1, false if it has a character Latin-1 cannot hold
+func stringToLatin1(s string) ([]byte, bool) {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xff {
+			return nil, false
+		}
+		b = append(b, byte(r))
+	}
+	return b, true
+}
+
+func latin1ToString(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// iccColorSpace returns the color space of an iCCP chunk's profile, ie. "RGB " or "GRAY"
+func iccColorSpace(data []byte) string {
+	_, profile, ok := bytes.Cut(data, []byte{0})
+	if !ok || len(profile) < 2 {
+		return ""
+	}
+	r, err := zlib.NewReader(bytes.NewReader(profile[1:])) // after the compression method
+	if err != nil {
+		return ""
+	}
+	defer r.Close()
+	header := make([]byte, 20)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return ""
+	}
+	return string(header[16:20])
+}
+
+// chunksFor returns the chunks to write with m. A color profile that does not match
+// the written color model, ie. an RGB profile on a gray output, is left out.
+func (meta *Metadata) chunksFor(m image.Image) []Chunk {
+	gray := false
+	switch m.(type) {
+	case *image.Gray, *image.Gray16:
+		gray = true
+	}
+	var chunks []Chunk
+	for _, chunk := range meta.Chunks {
+		if chunk.Type == "iCCP" && (iccColorSpace(chunk.Data) == "GRAY") != gray {
+			continue
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// insertChunks writes the chunks into an encoded PNG right after its IHDR chunk, which
+// is before PLTE and IDAT as the color chunks must be
+func insertChunks(encoded []byte, chunks []Chunk) []byte {
+	const ihdrEnd = len(pngSignature) + 12 + 13
+	if len(chunks) == 0 || len(encoded) < ihdrEnd {
+		return encoded
+	}
+	var out bytes.Buffer
+	out.Write(encoded[:ihdrEnd])
+	for _, chunk := range chunks {
+		var header [8]byte
+		binary.BigEndian.PutUint32(header[:4], uint32(len(chunk.Data)))
+		copy(header[4:], chunk.Type)
+		crc := crc32.NewIEEE()
+		crc.Write(header[4:])
+		crc.Write(chunk.Data)
+		out.Write(header[:])
+		out.Write(chunk.Data)
+		binary.Write(&out, binary.BigEndian, crc.Sum32())
+	}
+	out.Write(encoded[ihdrEnd:])
+	return out.Bytes()
+}
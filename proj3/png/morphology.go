@@ -0,0 +1,220 @@
+package png
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"sync"
+)
+
+// StructuringElement is the set of offsets around a pixel that erode and dilate look at
+type StructuringElement []image.Point
+
+// SquareElement returns a size x size square
+func SquareElement(size int) StructuringElement {
+	return squareWindow(size)
+}
+
+// CrossElement returns a plus shape size pixels wide and high
+func CrossElement(size int) StructuringElement {
+	r := size / 2
+	var se StructuringElement
+	for d := -r; d <= r; d++ {
+		se = append(se, image.Pt(d, 0))
+		if d != 0 {
+			se = append(se, image.Pt(0, d))
+		}
+	}
+	return se
+}
+
+// DiskElement returns the pixels within size/2 of the centre
+func DiskElement(size int) StructuringElement {
+	r := size / 2
+	var se StructuringElement
+	for _, p := range squareWindow(size) {
+		if p.X*p.X+p.Y*p.Y <= r*r {
+			se = append(se, p)
+		}
+	}
+	return se
+}
+
+// MaskElement returns the pixels set in an odd sized mask, row by row
+func MaskElement(mask [][]int) (StructuringElement, error) {
+	h := len(mask)
+	if h%2 == 0 {
+		return nil, fmt.Errorf("mask needs an odd number of rows, got %d", h)
+	}
+	var se StructuringElement
+	for y, row := range mask {
+		if len(row) != len(mask[0]) || len(row)%2 == 0 {
+			return nil, fmt.Errorf("mask rows must all have the same odd length")
+		}
+		for x, v := range row {
+			if v != 0 {
+				se = append(se, image.Pt(x-len(row)/2, y-h/2))
+			}
+		}
+	}
+	if len(se) == 0 {
+		return nil, fmt.Errorf("mask is empty")
+	}
+	return se, nil
+}
+
+// Erode replaces each pixel by the darkest pixel under the structuring element
+func (img *Image) Erode(se StructuringElement, boundaries ...image.Rectangle) {
+	img.rankFilter(se, 0, img.GetBoundary(boundaries...))
+}
+
+// Dilate replaces each pixel by the brightest pixel under the structuring element
+func (img *Image) Dilate(se StructuringElement, boundaries ...image.Rectangle) {
+	img.rankFilter(se, 100, img.GetBoundary(boundaries...))
+}
+
+// Binarize makes each pixel white if its luminance is at least threshold (0-255 scale) and black otherwise
+func (img *Image) Binarize(threshold float64, boundaries ...image.Rectangle) {
+	bounds := img.GetBoundary(boundaries...)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			a := img.In.RGBA64At(x, y).A
+			var v uint16
+			if luminance(img.In.RGBA64At(x, y)) >= threshold*257 {
+				v = a // white, premultiplied
+			}
+			img.Out.SetRGBA64(x, y, color.RGBA64{v, v, v, a})
+		}
+	}
+}
+
+// subtract writes a - b per color channel into Out, keeping the alpha of a
+func (img *Image) subtract(a, b *image.RGBA64, bounds image.Rectangle) {
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			ca, cb := a.RGBA64At(x, y), b.RGBA64At(x, y)
+			img.Out.SetRGBA64(x, y, color.RGBA64{
+				R: clamp(float64(ca.R) - float64(cb.R)),
+				G: clamp(float64(ca.G) - float64(cb.G)),
+				B: clamp(float64(ca.B) - float64(cb.B)),
+				A: ca.A,
+			})
+		}
+	}
+}
+
+// morphSnapshot keeps a copy of the image before a top-hat opens or closes it
+type morphSnapshot struct {
+	once sync.Once
+	pix  *image.RGBA64
+}
+
+// morphPass returns erode or dilate as a single superstep
+func morphPass(name string, se StructuringElement, percentile float64) Effect {
+	return Effect{Name: name, Radius: windowRadius(se), Apply: func(img *Image, bounds image.Rectangle) {
+		img.rankFilter(se, percentile, bounds)
+	}}
+}
+
+// NewMorphology returns the morphological operation op as an effect. Erode and dilate
+// are one superstep. Open, close and the top-hats expand to the erode and dilate passes
+// they are made of, plus a copy of the input and a difference pass for the top-hats.
+// A threshold >= 0 binarizes the image first.
+func NewMorphology(op string, se StructuringElement, threshold float64) (Effect, error) {
+	erode := morphPass(op+"/erode", se, 0)
+	dilate := morphPass(op+"/dilate", se, 100)
+	snapshot := &morphSnapshot{}
+
+	save := Effect{Name: op + "/save", Pointwise: true, NoSwap: true, Apply: func(img *Image, bounds image.Rectangle) {
+		snapshot.once.Do(func() {
+			snapshot.pix = image.NewRGBA64(img.Bounds)
+		})
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			copy(snapshot.pix.Pix[snapshot.pix.PixOffset(bounds.Min.X, y):snapshot.pix.PixOffset(bounds.Max.X, y)],
+				img.In.Pix[img.In.PixOffset(bounds.Min.X, y):img.In.PixOffset(bounds.Max.X, y)])
+		}
+	}}
+	// white top-hat: original - opened, black top-hat: closed - original
+	whiteHat := Effect{Name: op + "/difference", Pointwise: true, Apply: func(img *Image, bounds image.Rectangle) {
+		img.subtract(snapshot.pix, img.In, bounds)
+	}}
+	blackHat := Effect{Name: op + "/difference", Pointwise: true, Apply: func(img *Image, bounds image.Rectangle) {
+		img.subtract(img.In, snapshot.pix, bounds)
+	}}
+
+	var passes []Effect
+	if threshold >= 0 {
+		passes = append(passes, Effect{Name: op + "/binarize", Pointwise: true, Apply: func(img *Image, bounds image.Rectangle) {
+			img.Binarize(threshold, bounds)
+		}})
+	}
+	switch op {
+	case "erode":
+		passes = append(passes, erode)
+	case "dilate":
+		passes = append(passes, dilate)
+	case "open":
+		passes = append(passes, erode, dilate)
+	case "close":
+		passes = append(passes, dilate, erode)
+	case "tophat":
+		passes = append(passes, save, erode, dilate, whiteHat)
+	case "blackhat":
+		passes = append(passes, save, dilate, erode, blackHat)
+	default:
+		return Effect{}, fmt.Errorf("unknown morphological operation %q", op)
+	}
+	return Effect{Name: op, Radius: windowRadius(se), Passes: passes}, nil
+}
+
+// morphParams is the JSON form of the effects ie.
+// {"name": "open", "element": "disk", "size": 5} or {"name": "erode", "mask": [[0,1,0],[1,1,1],[0,1,0]], "binary": true}
+type morphParams struct {
+	Element   string  `json:"element"`
+	Size      int     `json:"size"`
+	Mask      [][]int `json:"mask"`
+	Binary    bool    `json:"binary"`
+	Threshold float64 `json:"threshold"`
+}
+
+// morphFactory returns the factory of the morphological operation op
+func morphFactory(op string) EffectFactory {
+	return func(params json.RawMessage) (Effect, error) {
+		p := morphParams{Element: "square", Size: 3, Threshold: 128}
+		if params != nil {
+			if err := decodeParams(params, &p); err != nil {
+				return Effect{}, err
+			}
+		}
+		if p.Mask != nil {
+			p.Element = "custom"
+		}
+		if p.Element != "custom" && (p.Size < 1 || p.Size%2 == 0 || p.Size > MaxWindow) {
+			return Effect{}, fmt.Errorf("size must be an odd number from 1 to %d, got %d", MaxWindow, p.Size)
+		}
+
+		var se StructuringElement
+		switch p.Element {
+		case "square":
+			se = SquareElement(p.Size)
+		case "cross":
+			se = CrossElement(p.Size)
+		case "disk":
+			se = DiskElement(p.Size)
+		case "custom":
+			var err error
+			if se, err = MaskElement(p.Mask); err != nil {
+				return Effect{}, err
+			}
+		default:
+			return Effect{}, fmt.Errorf("unknown structuring element %q", p.Element)
+		}
+
+		threshold := -1.0
+		if p.Binary {
+			threshold = p.Threshold
+		}
+		return NewMorphology(op, se, threshold)
+	}
+}
@@ -0,0 +1,47 @@
+package png
+
+import (
+	"image"
+	"image/color"
+)
+
+// The hot loops work on the Pix slice of the RGBA64 buffers instead of going through
+// At and Set, which box a color.Color and check the bounds for every pixel. A pixel
+// is 8 bytes, its four channels as big endian uint16, premultiplied; the offset of
+// (x, y) is given by the buffer's PixOffset.
+
+// pixel returns the channels of the pixel at offset i of pix
+func pixel(pix []uint8, i int) (r, g, b, a uint16) {
+	s := pix[i : i+8 : i+8] // one bounds check for the 8 bytes
+	return uint16(s[0])<<8 | uint16(s[1]), uint16(s[2])<<8 | uint16(s[3]),
+		uint16(s[4])<<8 | uint16(s[5]), uint16(s[6])<<8 | uint16(s[7])
+}
+
+// setPixel writes the channels of the pixel at offset i of pix
+func setPixel(pix []uint8, i int, r, g, b, a uint16) {
+	s := pix[i : i+8 : i+8]
+	s[0], s[1] = uint8(r>>8), uint8(r)
+	s[2], s[3] = uint8(g>>8), uint8(g)
+	s[4], s[5] = uint8(b>>8), uint8(b)
+	s[6], s[7] = uint8(a>>8), uint8(a)
+}
+
+// pixelColor returns the pixel at offset i of pix as a color
+func pixelColor(pix []uint8, i int) color.RGBA64 {
+	r, g, b, a := pixel(pix, i)
+	return color.RGBA64{r, g, b, a}
+}
+
+// setPixelColor writes c at offset i of pix
+func setPixelColor(pix []uint8, i int, c color.RGBA64) {
+	setPixel(pix, i, c.R, c.G, c.B, c.A)
+}
+
+// copyRows copies the pixels of bounds from src to dst, which cover it both
+func copyRows(dst, src *image.RGBA64, bounds image.Rectangle) {
+	n := 8 * bounds.Dx()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		i, j := dst.PixOffset(bounds.Min.X, y), src.PixOffset(bounds.Min.X, y)
+		copy(dst.Pix[i:i+n], src.Pix[j:j+n])
+	}
+}
@@ -1,12 +1,10 @@
-// Package png allows for loading png images and applying
+// Package png allows for loading png (and JPEG, GIF, BMP, TIFF, WebP) images and applying
 // image flitering effects on them
 package png
 
 import (
+	"bytes"
 	"image"
-	"image/color"
-	"image/png"
-	"math"
 	"os"
 	"fmt"
 )
@@ -17,6 +15,8 @@ type Image struct {
 	In     *image.RGBA64   //The original pixels before applying the effect
 	Out    *image.RGBA64   //The updated pixels after applying teh effect
 	Bounds image.Rectangle //The size of the image. By default is full image
+	Bits   int             //Bits per channel of the source, 8 or 16
+	Metadata Metadata      //Ancillary PNG chunks, written back by Save
 }
 
 //
@@ -25,17 +25,17 @@ type Image struct {
 
 // Load returns a Image that was loaded based on the filePath parameter
 // You are allowed to modify and update this as you wish
-func Load(filePath string) (*Image, error) {
+// The decoded image is converted by workers goroutines, one if no count is given.
+func Load(filePath string, workers ...int) (*Image, error) {
 	// output returns pointer to an Image structure, and an error
 
-	inReader, err := os.Open(filePath) 		// If file doesn't exist, returns error
+	file, err := os.ReadFile(filePath) 		// If file doesn't exist, returns error. Kept for the metadata
 
 	if err != nil {				// Check if can open file
 		return nil, err
 	}
-	defer inReader.Close()
 
-	inOrig, err := png.Decode(inReader)		// package image/png. decode te image as .png
+	inOrig, format, err := image.Decode(bytes.NewReader(file))		// the format is detected from the content, see format.go
 																				// if success inOrig is in image.Image format
 
 	if err != nil {				// decoding fails
@@ -44,43 +44,80 @@ func Load(filePath string) (*Image, error) {
 
 	bounds := inOrig.Bounds() 	// get size of original image. This will be used to set size of new image.
 
-	outImg := image.NewRGBA64(bounds)		// create new images outImg and inImg which are initially blank. // type : image.RGBA64
-	inImg := image.NewRGBA64(bounds)
+	outImg := image.NewRGBA64(bounds)		// create new images outImg which is initially blank. // type : image.RGBA64
 
-	// loop thru inOrig and copy into the inImg. We will use inImg for image processing.
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			r, g, b, a := inOrig.At(x, y).RGBA()
-			inImg.Set(x, y, color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)})
-		}
+	// copy inOrig into the inImg, row bands in parallel. We will use inImg for image processing.
+	threads := 1
+	if len(workers) > 0 {
+		threads = workers[0]
 	}
+	inImg := toRGBA64(inOrig, threads)
 
 	// initialize Image struct
 	task := &Image{}
 	task.In = inImg				// copied image
 	task.Out = outImg			// blank image
 	task.Bounds = bounds	// image boundary
+	task.Bits = sourceBits(inOrig)	// for DepthPreserve
+	if format == "png" {
+		task.Metadata = readMetadata(file)	// gamma, color profile, text...
+	}
 	return task, nil
 }
 
 // Save saves the image to the given file
 // You are allowed to modify and update this as you wish
-func (img *Image) Save(filePath string) error {
-
-	outWriter, err := os.Create(filePath)
+// The format follows the extension of filePath, options tune the encoder.
+func (img *Image) Save(filePath string, options ...SaveOptions) error {
+	var opts SaveOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	encode, err := encoderFor(filePath)
 	if err != nil {
 		return err
 	}
-	defer outWriter.Close()
 
-	err = png.Encode(outWriter, img.Out)
+	// encode in memory, the metadata chunks are spliced into a PNG before writing it
+	var encoded bytes.Buffer
+	out := img.output(opts.Depth)
+	err = encode(&encoded, out, opts)
 	if err != nil {
 		return err
 	}
-	return nil
+	data := encoded.Bytes()
+	if bytes.HasPrefix(data, []byte(pngSignature)) {
+		data = insertChunks(data, img.Metadata.chunksFor(out))
+	}
+	return os.WriteFile(filePath, data, 0644)
 }
 
 
+// Prepare gets Out ready for the pass and returns the bounds of Out, the region to split.
+// A pass that changes the size of the image gets a new Out buffer of its size.
+func (img *Image) Prepare(effect Effect) (image.Rectangle, error) {
+	if effect.OutBounds != nil {
+		bounds, err := effect.OutBounds(img.Bounds)
+		if err != nil {
+			return bounds, fmt.Errorf("%s: %w", effect.Name, err)
+		}
+		if bounds != img.Out.Bounds() {
+			img.Out = image.NewRGBA64(bounds)
+		}
+	}
+	return img.Out.Bounds(), nil
+}
+
+// Swap makes the result of the pass the input of the next one.
+// If the pass changed the size, Bounds follows the new In and Out is reallocated to match.
+func (img *Image) Swap() {
+	img.In, img.Out = img.Out, img.In
+	if img.In.Bounds() != img.Bounds {
+		img.Bounds = img.In.Bounds()
+		img.Out = image.NewRGBA64(img.Bounds)
+	}
+}
+
 // To compare result with expected
 func CompareImages(img1, img2 *Image) bool {
 	if img1.Bounds != img2.Bounds {
@@ -88,10 +125,12 @@ func CompareImages(img1, img2 *Image) bool {
 			return false
 	}
 	areIdentical := true
-	for y := 0; y < img1.Bounds.Dy(); y++ {
-			for x := 0; x < img1.Bounds.Dx(); x++ {
-					if img1.In.At(x, y) != img2.Out.At(x, y) {
-						fmt.Printf("Pixel difference at %d,%d: %v != %v\n", x, y, img1.In.At(x, y), img2.Out.At(x, y))
+	for y := img1.Bounds.Min.Y; y < img1.Bounds.Max.Y; y++ {
+			for x := img1.Bounds.Min.X; x < img1.Bounds.Max.X; x++ {
+					c1 := pixelColor(img1.In.Pix, img1.In.PixOffset(x, y))
+					c2 := pixelColor(img2.Out.Pix, img2.Out.PixOffset(x, y))
+					if c1 != c2 {
+						fmt.Printf("Pixel difference at %d,%d: %v != %v\n", x, y, c1, c2)
 						areIdentical = false
 					}
 			}
@@ -102,16 +141,18 @@ func CompareImages(img1, img2 *Image) bool {
 func (img *Image) SetInput(startX int, endX int, startY int, endY int){
 	// copy to input
 
-	for y := startY; y < endY; y++ {
-		for x := startX; x < endX; x++ {
-			r, g, b, a := img.Out.At(x, y).RGBA()
-			img.In.Set(x, y, color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)})
-		}
-	}
+	copyRows(img.In, img.Out, image.Rect(startX, startY, endX, endY))
 }
 
 //clamp will clamp the comp parameter to zero if it is less than zero or to 65535 if the comp parameter
 // is greater than 65535.
 func clamp(comp float64) uint16 {
-	return uint16(math.Min(65535, math.Max(0, comp)))
+	// plain comparisons, cheaper than math.Min and math.Max in the hot loops
+	if comp <= 0 {
+		return 0
+	}
+	if comp >= 65535 {
+		return 65535
+	}
+	return uint16(comp)
 }
@@ -0,0 +1,179 @@
+package png
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// MaxWindow bounds the size of the rank filter and morphology windows
+const MaxWindow = 101
+
+// squareWindow returns the offsets of a size x size window centred on the pixel
+func squareWindow(size int) []image.Point {
+	r := size / 2
+	window := make([]image.Point, 0, size*size)
+	for dy := -r; dy <= r; dy++ {
+		for dx := -r; dx <= r; dx++ {
+			window = append(window, image.Pt(dx, dy))
+		}
+	}
+	return window
+}
+
+// windowRadius returns the largest offset of the window, the halo it reads
+func windowRadius(window []image.Point) int {
+	radius := 0
+	for _, p := range window {
+		radius = Max(radius, Max(Max(p.X, -p.X), Max(p.Y, -p.Y)))
+	}
+	return radius
+}
+
+// selectRank returns the k-th smallest value of values, reordering them (quickselect)
+func selectRank(values []uint16, k int) uint16 {
+	lo, hi := 0, len(values)-1
+	for lo < hi {
+		pivot := values[(lo+hi)/2]
+		i, j := lo, hi
+		for i <= j {
+			for values[i] < pivot {
+				i++
+			}
+			for values[j] > pivot {
+				j--
+			}
+			if i <= j {
+				values[i], values[j] = values[j], values[i]
+				i++
+				j--
+			}
+		}
+		if k <= j {
+			hi = j
+		} else if k >= i {
+			lo = i
+		} else {
+			break
+		}
+	}
+	return values[k]
+}
+
+// rankFilter replaces each color channel by the value at percentile p (0 min, 50 median, 100 max)
+// of the pixels under the window. Neighbours outside the image are clamped to the edge.
+func (img *Image) rankFilter(window []image.Point, p float64, bounds image.Rectangle) {
+	full := img.Bounds
+	k := int(math.Round(p / 100 * float64(len(window)-1)))
+	rs := make([]uint16, len(window))
+	gs := make([]uint16, len(window))
+	bs := make([]uint16, len(window))
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			for i, off := range window {
+				nx := EdgeClamp.index(x+off.X, full.Min.X, full.Max.X)
+				ny := EdgeClamp.index(y+off.Y, full.Min.Y, full.Max.Y)
+				c := img.In.RGBA64At(nx, ny)
+				rs[i], gs[i], bs[i] = c.R, c.G, c.B
+			}
+
+			var col color.RGBA64
+			switch k {
+			case 0:
+				col.R, col.G, col.B = minOf(rs), minOf(gs), minOf(bs)
+			case len(window) - 1:
+				col.R, col.G, col.B = maxOf(rs), maxOf(gs), maxOf(bs)
+			default:
+				col.R, col.G, col.B = selectRank(rs, k), selectRank(gs, k), selectRank(bs, k)
+			}
+			col.A = img.In.RGBA64At(x, y).A
+
+			// keep the color premultiplied
+			col.R, col.G, col.B = Min16(col.R, col.A), Min16(col.G, col.A), Min16(col.B, col.A)
+			img.Out.SetRGBA64(x, y, col)
+		}
+	}
+}
+
+func minOf(values []uint16) uint16 {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+func maxOf(values []uint16) uint16 {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v > m {
+			m = v
+		}
+	}
+	return m
+}
+
+// Median applies a median filter over a size x size window, which removes salt-and-pepper noise
+func (img *Image) Median(size int, boundaries ...image.Rectangle) {
+	img.rankFilter(squareWindow(size), 50, img.GetBoundary(boundaries...))
+}
+
+// MinFilter replaces each pixel by the darkest value of its size x size window (erode)
+func (img *Image) MinFilter(size int, boundaries ...image.Rectangle) {
+	img.rankFilter(squareWindow(size), 0, img.GetBoundary(boundaries...))
+}
+
+// MaxFilter replaces each pixel by the brightest value of its size x size window (dilate)
+func (img *Image) MaxFilter(size int, boundaries ...image.Rectangle) {
+	img.rankFilter(squareWindow(size), 100, img.GetBoundary(boundaries...))
+}
+
+// Percentile replaces each pixel by the value at percentile p (0-100) of its size x size window
+func (img *Image) Percentile(size int, p float64, boundaries ...image.Rectangle) {
+	img.rankFilter(squareWindow(size), p, img.GetBoundary(boundaries...))
+}
+
+// rankParams is the JSON form of the effects ie. {"name": "percentile", "size": 5, "percentile": 25}
+type rankParams struct {
+	Size       int     `json:"size"`
+	Percentile float64 `json:"percentile"`
+}
+
+// fixedRankParams is the JSON form of the rank filters with a fixed percentile ie. {"name": "median", "size": 5},
+// which take no "percentile"
+type fixedRankParams struct {
+	Size int `json:"size"`
+}
+
+// rankFactory returns the factory of a rank filter. A negative percentile is read from the parameters.
+func rankFactory(name string, percentile float64) EffectFactory {
+	return func(params json.RawMessage) (Effect, error) {
+		p := rankParams{Size: 3, Percentile: percentile}
+		if params != nil && percentile >= 0 {
+			fixed := fixedRankParams{Size: p.Size}
+			if err := decodeParams(params, &fixed); err != nil {
+				return Effect{}, err
+			}
+			p.Size = fixed.Size
+		} else if params != nil {
+			if err := decodeParams(params, &p); err != nil {
+				return Effect{}, err
+			}
+		}
+		if p.Size < 1 || p.Size%2 == 0 || p.Size > MaxWindow {
+			return Effect{}, fmt.Errorf("size must be an odd number from 1 to %d, got %d", MaxWindow, p.Size)
+		}
+		if p.Percentile < 0 || p.Percentile > 100 {
+			return Effect{}, fmt.Errorf("percentile must be between 0 and 100, got %v", p.Percentile)
+		}
+		window := squareWindow(p.Size)
+		return Effect{Name: name, Radius: p.Size / 2, Apply: func(img *Image, bounds image.Rectangle) {
+			img.rankFilter(window, p.Percentile, bounds)
+		}}, nil
+	}
+}
@@ -0,0 +1,22 @@
+package png
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// median, min and max have their percentile fixed, so one given in the parameters is an
+// error rather than ignored
+func TestFixedRankFiltersRejectPercentile(t *testing.T) {
+	for _, name := range []string{"median", "min", "max"} {
+		if _, err := NewEffect(name, json.RawMessage(`{"name": "`+name+`", "size": 5, "percentile": 30}`)); err == nil {
+			t.Errorf("%s: a percentile was accepted", name)
+		}
+		if _, err := NewEffect(name, json.RawMessage(`{"name": "`+name+`", "size": 5}`)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, err := NewEffect("percentile", json.RawMessage(`{"name": "percentile", "size": 5, "percentile": 30}`)); err != nil {
+		t.Errorf("percentile: %v", err)
+	}
+}
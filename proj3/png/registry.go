@@ -0,0 +1,172 @@
+package png
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"sort"
+	"sync"
+)
+
+// EffectFunc applies an effect to the pixels of img inside bounds.
+// It reads from img.In and writes to img.Out, so slices of the same image can run concurrently.
+type EffectFunc func(img *Image, bounds image.Rectangle)
+
+// Effect is a filter that the schedulers can apply by name
+type Effect struct {
+	Name      string     // Code used in effects.txt ie. "G"
+	Apply     EffectFunc // Applies the effect on one region of the image
+	Radius    int        // How many neighbouring pixels each side of (x,y) the effect reads
+	Pointwise bool       // Out(x,y) only depends on In(x,y), so Radius is 0
+
+	// Passes makes a compound effect: the passes run one after the other, each as its own
+	// superstep, and Apply is not used.
+	Passes []Effect
+	// NoSwap is set on a pass that writes to a buffer of its own instead of Out,
+	// so the schedulers must not swap In and Out after it.
+	NoSwap bool
+	// Global is set on a pass that cannot be split into slices, ie. one following
+	// connections across the whole image. The schedulers call Apply once with the full bounds.
+	Global bool
+	// OutBounds is set on a pass that changes the size of the image, it returns the bounds
+	// of the result from the bounds of In, or an error when the pass cannot apply to an
+	// image of that size. See Image.Prepare and Image.Swap.
+	OutBounds func(in image.Rectangle) (image.Rectangle, error)
+
+	// Reduce is set instead of Apply on a pass that only reads the image, ie. to build a
+	// histogram. Each slice returns a partial result and Merge is called once with all of
+	// them after the barrier. Such a pass is also NoSwap.
+	Reduce func(img *Image, bounds image.Rectangle) interface{}
+	Merge  func(partials []interface{})
+}
+
+// Supersteps returns the effects the schedulers run one after the other, with a barrier
+// between each: the passes of a compound effect, or the effect itself.
+func (effect Effect) Supersteps() []Effect {
+	if len(effect.Passes) == 0 {
+		return []Effect{effect}
+	}
+	var steps []Effect
+	for _, pass := range effect.Passes {
+		steps = append(steps, pass.Supersteps()...)
+	}
+	return steps
+}
+
+// EffectFactory builds an effect from the parameters given in effects.txt.
+// params is the whole JSON object of the effect, or nil when it was given as a plain code.
+// The factory is called for every image, so the effect may keep per-image state in its closures.
+type EffectFactory func(params json.RawMessage) (Effect, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]EffectFactory)
+)
+
+// RegisterEffect makes an effect available to every scheduler mode under effect.Name.
+// Other packages can call it from their init function to add their own effects.
+// It panics if the name is empty, already taken or there is nothing to apply.
+// The same Effect value is handed out for every image, so it must not keep state.
+func RegisterEffect(effect Effect) {
+	if effect.Apply == nil && effect.Reduce == nil && len(effect.Passes) == 0 {
+		panic("png: RegisterEffect needs an Apply function or Passes")
+	}
+	RegisterEffectFactory(effect.Name, func(json.RawMessage) (Effect, error) {
+		return effect, nil
+	})
+}
+
+// RegisterEffectFactory registers an effect that takes parameters under name.
+// It panics if the name is empty, already taken or factory is nil.
+func RegisterEffectFactory(name string, factory EffectFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if name == "" || factory == nil {
+		panic("png: an effect needs a name and a factory")
+	}
+	if _, dup := registry[name]; dup {
+		panic(fmt.Sprintf("png: effect %q registered twice", name))
+	}
+	registry[name] = factory
+}
+
+// NewEffect builds the effect registered under name with the given parameters
+func NewEffect(name string, params json.RawMessage) (Effect, error) {
+	registryMu.RLock()
+	factory, ok := registry[name]
+	registryMu.RUnlock()
+
+	if !ok {
+		return Effect{}, fmt.Errorf("unknown effect %q", name)
+	}
+	effect, err := factory(params)
+	if err != nil {
+		return Effect{}, fmt.Errorf("%s: %w", name, err)
+	}
+	return effect, nil
+}
+
+// decodeParams decodes the parameters of an effect into v, skipping the "name" field.
+// A field v does not have is an error, so a misspelled parameter is not silently ignored.
+func decodeParams(params json.RawMessage, v interface{}) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(params, &fields); err != nil {
+		return err
+	}
+	delete(fields, "name")
+	stripped, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(stripped))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// LookupEffect returns the effect registered under name with its default parameters
+func LookupEffect(name string) (Effect, bool) {
+	effect, err := NewEffect(name, nil)
+	return effect, err == nil
+}
+
+// EffectNames returns the names of all registered effects in sorted order
+func EffectNames() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The built-in effects
+func init() {
+	RegisterEffectFactory("G", newGrayscaleEffect)
+	RegisterEffectFactory("E", builtinKernelFactory("E", edgeDetectionWeights))
+	RegisterEffectFactory("S", builtinKernelFactory("S", sharpenWeights))
+	RegisterEffectFactory("B", builtinKernelFactory("B", blurWeights))
+	RegisterEffectFactory("kernel", newKernelEffect)
+	RegisterEffectFactory("gaussian", newGaussianEffect)
+	RegisterEffectFactory("sobel", gradientFactory("sobel", Sobel))
+	RegisterEffectFactory("prewitt", gradientFactory("prewitt", Prewitt))
+	RegisterEffectFactory("canny", newCannyEffect)
+	RegisterEffectFactory("median", rankFactory("median", 50))
+	RegisterEffectFactory("min", rankFactory("min", 0))
+	RegisterEffectFactory("max", rankFactory("max", 100))
+	RegisterEffectFactory("percentile", rankFactory("percentile", -1))
+	for _, op := range []string{"erode", "dilate", "open", "close", "tophat", "blackhat"} {
+		RegisterEffectFactory(op, morphFactory(op))
+	}
+	registerAdjustEffects()
+	for _, name := range []string{"crop", "flip", "rotate", "resize"} {
+		RegisterEffectFactory(name, geometryFactory(name))
+	}
+	RegisterEffectFactory("equalize", newEqualizeEffect)
+	RegisterEffectFactory("clahe", newCLAHEEffect)
+	RegisterEffectFactory("chromakey", newChromaKeyEffect)
+}
@@ -0,0 +1,232 @@
+package png
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sync"
+)
+
+// SeparableKernel is a kernel that is the outer product of a column and a row vector,
+// ie. box, Gaussian or the Sobel components. It runs as a horizontal pass followed
+// by a vertical pass, reading Width+Height pixels per pixel instead of Width*Height.
+type SeparableKernel struct {
+	Row      []float64 // Horizontal weights, odd length
+	Column   []float64 // Vertical weights, odd length
+	Divisor  float64   // Same meaning as in Kernel
+	Bias     float64
+	Edge     EdgeMode
+	Fill     color.RGBA64
+	Straight bool
+}
+
+// Radius returns how many neighbours the kernel reads on each side of a pixel, in x and y
+func (k SeparableKernel) Radius() (int, int) {
+	return len(k.Row) / 2, len(k.Column) / 2
+}
+
+// Kernel returns the full 2D kernel
+func (k SeparableKernel) Kernel() Kernel {
+	weights := make([]float64, 0, len(k.Row)*len(k.Column))
+	for _, c := range k.Column {
+		for _, r := range k.Row {
+			weights = append(weights, c*r)
+		}
+	}
+	return Kernel{Weights: weights, Width: len(k.Row), Height: len(k.Column), Divisor: k.Divisor, Bias: k.Bias, Edge: k.Edge, Fill: k.Fill, Straight: k.Straight}
+}
+
+// Separable splits the kernel into a column and a row vector when it has rank 1
+func (k Kernel) Separable() (SeparableKernel, bool) {
+	w, h := k.Size()
+
+	// pivot on the largest weight to keep the division stable
+	pivot := 0
+	for i, weight := range k.Weights {
+		if math.Abs(weight) > math.Abs(k.Weights[pivot]) {
+			pivot = i
+		}
+	}
+	if k.Weights[pivot] == 0 {
+		return SeparableKernel{}, false
+	}
+	pr, pc := pivot/w, pivot%w
+
+	sep := SeparableKernel{Row: make([]float64, w), Column: make([]float64, h), Divisor: k.Divisor, Bias: k.Bias, Edge: k.Edge, Fill: k.Fill, Straight: k.Straight}
+	for x := 0; x < w; x++ {
+		sep.Row[x] = k.Weights[pr*w+x] / k.Weights[pivot]
+	}
+	for y := 0; y < h; y++ {
+		sep.Column[y] = k.Weights[y*w+pc]
+	}
+
+	// every weight must be column * row
+	tolerance := 1e-9 * math.Abs(k.Weights[pivot])
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if math.Abs(sep.Column[y]*sep.Row[x]-k.Weights[y*w+x]) > tolerance {
+				return SeparableKernel{}, false
+			}
+		}
+	}
+	return sep, true
+}
+
+// separableBuffer holds the result of the horizontal pass as float32 so that
+// negative and out of range sums survive until the vertical pass
+type separableBuffer struct {
+	once sync.Once
+	pix  []float32 // 4 channels per pixel over the full image
+}
+
+func (buf *separableBuffer) get(bounds image.Rectangle) []float32 {
+	buf.once.Do(func() {
+		buf.pix = make([]float32, 4*bounds.Dx()*bounds.Dy())
+	})
+	return buf.pix
+}
+
+// Passes returns the two passes of the kernel as effects, to be run one after the other.
+// The horizontal pass fills a buffer of its own and leaves Out alone, so it is marked NoSwap.
+func (k SeparableKernel) Passes(name string) []Effect {
+	buf := &separableBuffer{}
+	rx, ry := k.Radius()
+	return []Effect{
+		{Name: name + "/rows", Radius: rx, NoSwap: true, Apply: func(img *Image, bounds image.Rectangle) {
+			img.convolveRows(k, buf.get(img.Bounds), bounds)
+		}},
+		{Name: name + "/columns", Radius: ry, Apply: func(img *Image, bounds image.Rectangle) {
+			img.convolveColumns(k, buf.get(img.Bounds), bounds)
+		}},
+	}
+}
+
+// ConvolveSeparable applies both passes of a separable kernel on the image
+func (img *Image) ConvolveSeparable(kernel SeparableKernel, boundaries ...image.Rectangle) {
+	bounds := img.GetBoundary(boundaries...)
+	buf := make([]float32, 4*img.Bounds.Dx()*img.Bounds.Dy())
+
+	// the vertical pass reads the rows around bounds too, from the far side of the image
+	// with wrap, so the horizontal pass does every row the edge mode can reach
+	_, ry := kernel.Radius()
+	full := img.Bounds
+	minY, maxY := bounds.Max.Y, bounds.Min.Y
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for ky := -ry; ky <= ry; ky++ {
+			ny := kernel.Edge.index(y+ky, full.Min.Y, full.Max.Y)
+			minY, maxY = Min(minY, ny), Max(maxY, ny+1)
+		}
+	}
+	rows := image.Rect(bounds.Min.X, minY, bounds.Max.X, maxY).Intersect(full)
+	img.convolveRows(kernel, buf, rows)
+	img.convolveColumns(kernel, buf, bounds)
+}
+
+// convolveRows is the horizontal pass: In * Row into buf.
+// Every row of bounds is done, even in the frame, because the vertical pass reads them.
+func (img *Image) convolveRows(kernel SeparableKernel, buf []float32, bounds image.Rectangle) {
+	full := img.Bounds
+	rx, _ := kernel.Radius()
+	startX, endX := bounds.Min.X, bounds.Max.X
+	if kernel.Edge == EdgeSkip {
+		startX, endX = Max(startX, full.Min.X+rx), Min(endX, full.Max.X-rx)
+	}
+
+	// the window of x is inside the row from x = minX to maxX-1, read from Pix there
+	minX, maxX := full.Min.X+rx, full.Max.X-rx
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := startX; x < endX; x++ {
+			var sumR, sumG, sumB, sumA float64
+			inside := x >= minX && x < maxX
+			p := img.In.PixOffset(x-rx, y)
+			for kx := -rx; kx <= rx; kx++ {
+				var r, g, b, a uint16
+				if inside {
+					r, g, b, a = pixel(img.In.Pix, p)
+					p += 8
+				} else {
+					c := img.edgePixel(kernel.Edge, kernel.Fill, x+kx, y)
+					r, g, b, a = c.R, c.G, c.B, c.A
+				}
+				fr, fg, fb, fa := kernelInput(r, g, b, a, kernel.Straight)
+				weight := kernel.Row[kx+rx]
+				sumR += weight * fr
+				sumG += weight * fg
+				sumB += weight * fb
+				sumA += weight * fa
+			}
+			i := 4 * ((y-full.Min.Y)*full.Dx() + (x - full.Min.X))
+			buf[i], buf[i+1], buf[i+2], buf[i+3] = float32(sumR), float32(sumG), float32(sumB), float32(sumA)
+		}
+	}
+}
+
+// convolveColumns is the vertical pass: buf * Column into Out
+func (img *Image) convolveColumns(kernel SeparableKernel, buf []float32, bounds image.Rectangle) {
+	full := img.Bounds
+	rx, ry := kernel.Radius()
+	startX, endX, startY, endY := bounds.Min.X, bounds.Max.X, bounds.Min.Y, bounds.Max.Y
+	if kernel.Edge == EdgeSkip {
+		inner := innerBounds(full, rx, ry)
+		img.copyFrame(bounds, inner)
+		startX, endX = Max(startX, inner.Min.X), Min(endX, inner.Max.X)
+		startY, endY = Max(startY, inner.Min.Y), Min(endY, inner.Max.Y)
+	}
+
+	// A row outside the image is all Fill with EdgeConstant, so the horizontal pass gives Fill * sum(Row)
+	rowSum := sumOf(kernel.Row)
+	fill := kernel.Fill
+	fillR, fillG, fillB, fillA := kernelInput(fill.R, fill.G, fill.B, fill.A, kernel.Straight)
+	fillR, fillG, fillB, fillA = fillR*rowSum, fillG*rowSum, fillB*rowSum, fillA*rowSum
+
+	// alpha is convolved with the weights scaled to sum to 1, like applyKernel
+	weightSum := rowSum * sumOf(kernel.Column)
+
+	divisor := kernel.Divisor
+	if divisor == 0 {
+		divisor = 1
+	}
+	bias := kernel.Bias * 257 // 0-255 scale to 0-65535
+
+	step := 4 * full.Dx() // one row down in buf
+	for y := startY; y < endY; y++ {
+		// the window of a row inside the image is read from buf without the edge mode
+		inside := y-ry >= full.Min.Y && y+ry < full.Max.Y
+		for x := startX; x < endX; x++ {
+			var sumR, sumG, sumB, sumA float64
+			if inside {
+				i := 4 * ((y-ry-full.Min.Y)*full.Dx() + (x - full.Min.X))
+				for _, weight := range kernel.Column {
+					sumR += weight * float64(buf[i])
+					sumG += weight * float64(buf[i+1])
+					sumB += weight * float64(buf[i+2])
+					sumA += weight * float64(buf[i+3])
+					i += step
+				}
+			}
+			for ky := -ry; ky <= ry && !inside; ky++ {
+				weight := kernel.Column[ky+ry]
+				if kernel.Edge == EdgeConstant && (y+ky < full.Min.Y || y+ky >= full.Max.Y) {
+					sumR += weight * fillR
+					sumG += weight * fillG
+					sumB += weight * fillB
+					sumA += weight * fillA
+					continue
+				}
+				ny := kernel.Edge.index(y+ky, full.Min.Y, full.Max.Y)
+				i := 4 * ((ny-full.Min.Y)*full.Dx() + (x - full.Min.X))
+				sumR += weight * float64(buf[i])
+				sumG += weight * float64(buf[i+1])
+				sumB += weight * float64(buf[i+2])
+				sumA += weight * float64(buf[i+3])
+			}
+
+			_, _, _, centre := pixel(img.In.Pix, img.In.PixOffset(x, y))
+			alpha := float64(centre)
+			if weightSum != 0 {
+				alpha = sumA / weightSum
+			}
+			setPixelColor(img.Out.Pix, img.Out.PixOffset(x, y), kernelOutput(sumR/divisor, sumG/divisor, sumB/divisor, alpha, bias, kernel.Straight))
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package png
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+// ConvolveSeparable on slices of the image must give the result on the whole image,
+// whichever rows the edge mode reads
+func TestConvolveSeparableSlices(t *testing.T) {
+	for _, edge := range []EdgeMode{EdgeClamp, EdgeReflect, EdgeWrap, EdgeConstant, EdgeSkip} {
+		kernel := GaussianKernel(1.5)
+		kernel.Edge = edge
+		whole := testImage(7, 9)
+		whole.ConvolveSeparable(kernel)
+
+		sliced := testImage(7, 9)
+		for y := 0; y < 9; y += 2 {
+			sliced.ConvolveSeparable(kernel, image.Rect(0, y, 7, Min(y+2, 9)))
+		}
+		if !bytes.Equal(sliced.Out.Pix, whole.Out.Pix) {
+			t.Errorf("edge mode %d: slices differ from the whole image", edge)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package png
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// ChannelStats summarizes one channel on the 0-255 scale
+type ChannelStats struct {
+	Min    int     `json:"min"`
+	Max    int     `json:"max"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+}
+
+// Stats describes the pixels of an image. The color channels are straight (not
+// premultiplied) and leave out fully transparent pixels like the Histogram, the
+// alpha channel counts every pixel.
+type Stats struct {
+	Width     int          `json:"width"`
+	Height    int          `json:"height"`
+	Red       ChannelStats `json:"red"`
+	Green     ChannelStats `json:"green"`
+	Blue      ChannelStats `json:"blue"`
+	Alpha     ChannelStats `json:"alpha"`
+	Histogram *Histogram   `json:"histogram"`
+	// Checksum of the 16-bit pixels and their positions. It only depends on the
+	// pixels, not on how the image was split between threads.
+	Checksum string `json:"checksum"`
+}
+
+// statsPartial is what one slice counts, partials are merged by adding them up
+type statsPartial struct {
+	histogram Histogram
+	alpha     [256]uint64
+	checksum  uint64
+}
+
+// mix64 is the splitmix64 finalizer
+func mix64(v uint64) uint64 {
+	v ^= v >> 30
+	v *= 0xbf58476d1ce4e5b9
+	v ^= v >> 27
+	v *= 0x94d049bb133111eb
+	v ^= v >> 31
+	return v
+}
+
+func (img *Image) statsPartial(bounds image.Rectangle) *statsPartial {
+	partial := &statsPartial{histogram: *img.Histogram(bounds)}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := img.In.RGBA64At(x, y)
+			partial.alpha[c.A>>8]++
+			// summing the pixel hashes makes the checksum independent of the order of the slices
+			packed := uint64(c.R)<<48 | uint64(c.G)<<32 | uint64(c.B)<<16 | uint64(c.A)
+			partial.checksum += mix64(packed ^ mix64(uint64(uint32(y))<<32|uint64(uint32(x))))
+		}
+	}
+	return partial
+}
+
+func (partial *statsPartial) add(other *statsPartial) {
+	partial.histogram.Add(&other.histogram)
+	for i, n := range other.alpha {
+		partial.alpha[i] += n
+	}
+	partial.checksum += other.checksum
+}
+
+// channelStats summarizes the counts of a channel's 256 levels
+func channelStats(counts *[256]uint64) ChannelStats {
+	var stats ChannelStats
+	var total uint64
+	var sum, sumSq float64
+	stats.Min = -1
+	for level, n := range counts {
+		if n == 0 {
+			continue
+		}
+		if stats.Min < 0 {
+			stats.Min = level
+		}
+		stats.Max = level
+		total += n
+		sum += float64(n) * float64(level)
+		sumSq += float64(n) * float64(level) * float64(level)
+	}
+	if total == 0 {
+		return ChannelStats{}
+	}
+	stats.Mean = sum / float64(total)
+	stats.StdDev = math.Sqrt(math.Max(0, sumSq/float64(total)-stats.Mean*stats.Mean))
+	return stats
+}
+
+func (partial *statsPartial) stats(bounds image.Rectangle) Stats {
+	h := partial.histogram
+	return Stats{
+		Width:     bounds.Dx(),
+		Height:    bounds.Dy(),
+		Red:       channelStats(&h.Red),
+		Green:     channelStats(&h.Green),
+		Blue:      channelStats(&h.Blue),
+		Alpha:     channelStats(&partial.alpha),
+		Histogram: &h,
+		Checksum:  fmt.Sprintf("%016x", partial.checksum),
+	}
+}
+
+// Stats computes the statistics of In inside the bounds on the calling goroutine
+func (img *Image) Stats(boundaries ...image.Rectangle) Stats {
+	bounds := img.GetBoundary(boundaries...)
+	return img.statsPartial(bounds).stats(bounds)
+}
+
+// StatsEffect returns a reduction pass computing the statistics of img into dst, so the
+// schedulers split it like any other effect: each slice counts its rows and the partial
+// results are merged after the barrier. It leaves the image alone.
+func StatsEffect(img *Image, dst *Stats) Effect {
+	return Effect{Name: "stats", NoSwap: true, Pointwise: true,
+		Reduce: func(img *Image, bounds image.Rectangle) interface{} {
+			return img.statsPartial(bounds)
+		},
+		Merge: func(partials []interface{}) {
+			total := &statsPartial{}
+			for _, partial := range partials {
+				total.add(partial.(*statsPartial))
+			}
+			*dst = total.stats(img.Bounds)
+		},
+	}
+}
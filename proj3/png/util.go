@@ -14,3 +14,10 @@ func Max(a, b int) int {
 	}
 	return b
 }
+
+func Min16(a, b uint16) uint16 {
+	if a < b {
+			return a
+	}
+	return b
+}
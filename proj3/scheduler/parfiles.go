@@ -26,16 +26,26 @@ func (l *TASLock) Unlock() {
 }
 
 // main function
-func RunParallelFiles(config Config) {
+func RunParallelFiles(config Config) (Results, error) {
 	var wg sync.WaitGroup
 
-	queue, err := ReadTasksToQueue("../data/effects.txt", config.DataDirs)
+	queue, err := ReadTasksToQueue(config)
 	if err != nil {
-		panic(err) // Or handle error more gracefully
+		return nil, err
+	}
+
+	skipped, err := validateQueue(queue, config.EffectsPath, config.Strict)
+	if err != nil {
+		return nil, err
 	}
 
 	lock := &TASLock{}
 
+	// One slot per task so each worker records its result in task order
+	taskCount := queue.GetLength()
+	tasks := append([]ImageTask(nil), queue.GetTasks()...)
+	results := make(Results, taskCount)
+
 	actualNumThreads := png.Min(queue.GetLength(), config.ThreadCount)
 
 	start := time.Now()
@@ -51,10 +61,12 @@ func RunParallelFiles(config Config) {
 									lock.Unlock()
 									break
 							}
+							index := taskCount - queue.GetLength()
 							task := queue.Dequeue()
 							lock.Unlock()
 
-							ProcessImage(&task) // Your image processing function
+							err := ProcessImage(&task) // Your image processing function
+							results[index] = newResult(&task, err)		// a failure does not stop this worker
 					}
 					wg.Done()
 			}()
@@ -62,9 +74,16 @@ func RunParallelFiles(config Config) {
 
 	wg.Wait() // Wait for all goroutines to finish
 
+	for i := range results {
+		if results[i].Status == StatusNotRun {		// no worker reached this task
+			results[i] = newResult(&tasks[i], fmt.Errorf("task was not run"))
+		}
+	}
+
 	// --------- End Parallel program for 10 images ---------
 
 	end := time.Since(start).Seconds()
 	fmt.Printf("Parallelize Time : %.2f\n", end)		// Measure Parallelize time
 
+	return append(skipped, results...), nil
 }
@@ -13,13 +13,34 @@ import (
 // ---------------- Helper Function ---- //
 // Sprawn go process by heights and wait till everyone's done.
 // will slice by height. Each thread takes x rows to do task
-func ProcessParallelSlices(pngImg *png.Image, numThreads int, effect string) (float64, *png.Image) {
+// A slice only limits the rows written to Out. The kernel reads its halo of effect.Radius rows
+// above and below straight from the shared In buffer, so slices match the sequential output.
+func ProcessParallelSlices(pngImg *png.Image, numThreads int, effect png.Effect) (float64, *png.Image, error) {
+
+	// Split the rows of the output, which is not the size of the input after a resize
+	bounds, err := pngImg.Prepare(effect)
+	if err != nil {
+		return 0, pngImg, err
+	}
+
+	// A global pass cannot be split, run it on this goroutine between the supersteps
+	if effect.Global {
+		start := time.Now()
+		partial, err := processImageSection(pngImg, bounds, effect)
+		if err == nil && partial != nil {
+			err = mergePartials(effect, []interface{}{partial})
+		}
+		return time.Since(start).Seconds(), pngImg, err
+	}
 
 	var wg sync.WaitGroup
 
-	bounds := pngImg.Bounds					// have to access first then call Dx() on value it returns
 	height := bounds.Dy()
 
+	// One slot per goroutine for the partial results of a Reduce pass, and for a panic of the effect
+	partials := make([]interface{}, numThreads)
+	errs := make([]error, numThreads)
+
 	rowPerThread := int(math.Ceil(float64(height) / float64(numThreads)))
 
 	actualNumThreads := png.Min(height, numThreads)  // min(no. all jobs, threadcount) // Ensure we do not spawn more threads than intervals
@@ -39,50 +60,57 @@ func ProcessParallelSlices(pngImg *png.Image, numThreads int, effect string) (fl
 				// note: pngImg and task is already a pointer
 
 				// go ProcessImageChunk(pngImg, task, bounds.Min.X, chunkStartY, bounds.Max.X, chunkEndY, &wg)
-				bounds := image.Rect(bounds.Min.X, chunkStartY, bounds.Max.X, chunkEndY)
+				bounds := image.Rect(bounds.Min.X, bounds.Min.Y+chunkStartY, bounds.Max.X, bounds.Min.Y+chunkEndY)
 
-				go func (bounds image.Rectangle) {
+				go func (i int, bounds image.Rectangle) {
 					// TODO
 					defer wg.Done()		// will be called as soon as go routine completed
 
-					switch effect {
-					case "G" :
-						pngImg.Grayscale(bounds)
-					case "E":
-						pngImg.EdgeDetection(bounds)
-					case "S":
-						pngImg.Sharpen(bounds)
-					case "B":
-						pngImg.Blur(bounds)
-					}
-				}(bounds)
+					partials[i], errs[i] = processImageSection(pngImg, bounds, effect)
+				}(i, bounds)
 		} else {
 			wg.Done() // If no work is added, immediately call wg.Done
 		}
 	}
 	wg.Wait() 	// done this effect
+	for _, err := range errs {
+		if err != nil {
+			return time.Since(start).Seconds(), pngImg, err
+		}
+	}
+	if effect.Reduce != nil {
+		if err := mergePartials(effect, partials); err != nil {
+			return time.Since(start).Seconds(), pngImg, err
+		}
+	}
 	// --------- End Parallel program for this effect ---------
 	end := time.Since(start).Seconds()
 
 	// fmt.Printf("  Parallelize Time for effect : %.2f\n" , end)		// Measure Parallelize time
 
-	return end, pngImg
+	return end, pngImg, nil
 }
 
 // ---------------- End Helper Function ---- //
 
 
 // Main function pop each image from q
-func RunParallelSlices(config Config) {
+func RunParallelSlices(config Config) (Results, error) {
 
 	// var pngImg *png.Image
 	var time_ float64
 
 	totalParallelTime := 0.0 	//accumulate parallel time
 
-	queue, err := ReadTasksToQueue("../data/effects.txt", config.DataDirs)
+	queue, err := ReadTasksToQueue(config)
 	if err != nil {
-		panic(err) // Or handle error more gracefully
+		return nil, err
+	}
+
+	// Invalid tasks are reported first, the rest are processed
+	results, err := validateQueue(queue, config.EffectsPath, config.Strict)
+	if err != nil {
+		return nil, err
 	}
 
 	// pop image from queue
@@ -92,20 +120,50 @@ func RunParallelSlices(config Config) {
 		}
 		task := queue.Dequeue()
 
-		pngImg, err := png.Load(task.InPath); if err != nil {panic(err)}
+		pngImg, err := png.Load(task.InPath, config.LoadThreads); if err != nil {
+			results = append(results, newResult(&task, fmt.Errorf("load: %w", err)))		// skip to the next image
+			continue
+		}
+
+		effects, err := taskEffects(&task)
+		if err != nil {
+			results = append(results, newResult(&task, err))
+			continue
+		}
 
 		// Performs a X filtering effect on the image
-		for _, effect := range task.Effects {
-			time_, pngImg = ProcessParallelSlices(pngImg, config.ThreadCount, effect)
-			pngImg.In, pngImg.Out = pngImg.Out, pngImg.In		//Swap pointers
+		for _, effect := range effects {
+			time_, pngImg, err = ProcessParallelSlices(pngImg, config.ThreadCount, effect)
+			if err != nil {
+				break
+			}
+			if !effect.NoSwap {
+				pngImg.Swap()		//Swap pointers
+			}
 			totalParallelTime += time_
 		}
+		if err != nil {
+			results = append(results, newResult(&task, err))
+			continue
+		}
+
+		// the statistics are a reduction split between the threads like the effects
+		err = saveStats(&task, pngImg, func(effect png.Effect) error {
+			_, _, err := ProcessParallelSlices(pngImg, config.ThreadCount, effect)
+			return err
+		})
+		if err != nil {
+			results = append(results, newResult(&task, err))
+			continue
+		}
 
 		pngImg.Out, pngImg.In = pngImg.In, pngImg.Out
 		//Saves the image to a new file
-		err = pngImg.Save(task.OutPath); if err != nil {
-			panic(err)		// check for error while saving
+		err = saveImage(pngImg, &task); if err != nil {
+			err = fmt.Errorf("save: %w", err)		// check for error while saving
 		}
+		results = append(results, newResult(&task, err))
 		fmt.Printf("Accumulate Parallel Time 10 images: %.2f seconds\n", totalParallelTime)
 	}
+	return results, nil
 }
@@ -13,15 +13,20 @@ import (
 	"math/rand"
 )
 
+// Number of deque tasks per thread for a pointwise effect, instead of 2
+const pointwiseTasksPerThread = 8
+
 type SharedContex struct {
 	wgContext   *sync.WaitGroup
 	mutex       *sync.Mutex
 	cond        *sync.Cond
 	counter     int
 	threadCount int
+	partials    [][]interface{} // Results of a Reduce pass, one list per worker
+	errs        []error         // First panic of each worker, see processImageSection
 }
 
-func worker(id int, deques []*deque.DEQueue, ctx *SharedContex, img *png.Image, effect string) {
+func worker(id int, deques []*deque.DEQueue, ctx *SharedContex, img *png.Image, effect png.Effect) {
 	myDeque := deques[id]
 	wg := ctx.wgContext
 	defer wg.Done()
@@ -62,7 +67,13 @@ func worker(id int, deques []*deque.DEQueue, ctx *SharedContex, img *png.Image,
 			fmt.Printf("Go %d finished\n", id)
 			break // No tasks available anywhere, exit
 		}
-		processImageSection(img, task.Bounds, effect)
+		partial, err := processImageSection(img, task.Bounds, effect)
+		if err != nil && ctx.errs[id] == nil {
+			ctx.errs[id] = err		// keep taking tasks so the others are not left waiting at the barrier
+		}
+		if partial != nil {
+			ctx.partials[id] = append(ctx.partials[id], partial)
+		}
 	}
 
 	/****barrier synchronization****/
@@ -79,16 +90,37 @@ func worker(id int, deques []*deque.DEQueue, ctx *SharedContex, img *png.Image,
 	/****barrier synchronization****/
 }
 
-func processImageSection(pngImg *png.Image, bounds image.Rectangle, effect string) {
-	switch effect {
-	case "G":
-		pngImg.Grayscale(bounds)
-	case "E":
-		pngImg.EdgeDetection(bounds)
-	case "S":
-		pngImg.Sharpen(bounds)
-	case "B":
-		pngImg.Blur(bounds)
+// processImageSection runs the effect on bounds, and returns the partial result of a Reduce pass or nil.
+// A panic in the effect is returned as an error, so it only fails the image it was working on.
+func processImageSection(pngImg *png.Image, bounds image.Rectangle, effect png.Effect) (partial interface{}, err error) {
+	defer recoverEffect(effect, &err)
+	if effect.Reduce != nil {
+		return effect.Reduce(pngImg, bounds), nil
+	}
+	effect.Apply(pngImg, bounds)
+	return nil, nil
+}
+
+// mergePartials hands the non nil partial results of a Reduce pass to its Merge
+func mergePartials(effect png.Effect, partials []interface{}) (err error) {
+	if effect.Merge == nil {
+		return nil
+	}
+	defer recoverEffect(effect, &err)
+	merged := make([]interface{}, 0, len(partials))
+	for _, partial := range partials {
+		if partial != nil {
+			merged = append(merged, partial)
+		}
+	}
+	effect.Merge(merged)
+	return nil
+}
+
+// recoverEffect turns a panic of the effect into an error in *err, it must be deferred
+func recoverEffect(effect png.Effect, err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("%s: panic: %v", effect.Name, r)
 	}
 }
 
@@ -96,12 +128,32 @@ func processImageSection(pngImg *png.Image, bounds image.Rectangle, effect strin
 // Sprawn go process by heights to apply one effect.
 // Each thread takes x rows and wait until all threads are done.
 // return time taken to process and the image with one effect applied.
-func ProcessParallelSlicesBSP(pngImg *png.Image, numThreads int, effect string, optimized bool) (float64, *png.Image) {
-	bounds := pngImg.Bounds
+func ProcessParallelSlicesBSP(pngImg *png.Image, numThreads int, effect png.Effect, optimized bool) (float64, *png.Image, error) {
+	// The tasks split the rows of the output, which is not the size of the input after a resize
+	bounds, err := pngImg.Prepare(effect)
+	if err != nil {
+		return 0, pngImg, err
+	}
+
+	// A global pass cannot be split into deque tasks. Every worker has passed the barrier
+	// of the previous superstep, so run it here before the next one is enqueued.
+	if effect.Global {
+		start := time.Now()
+		partial, err := processImageSection(pngImg, bounds, effect)
+		if err == nil && partial != nil {
+			err = mergePartials(effect, []interface{}{partial})
+		}
+		return time.Since(start).Seconds(), pngImg, err
+	}
+
 	height := bounds.Dy()
 
 	/**** Adjust TaskCount per Thread here *****/
 	taskCount := numThreads * 2
+	if effect.Pointwise {
+		// No halo to read, so smaller tasks cost nothing extra and give stealing more to balance
+		taskCount = numThreads * pointwiseTasksPerThread
+	}
 
 	chunkSize := int(math.Ceil(float64(height) / float64(taskCount)))
 	actualNumThreads := png.Min(height, numThreads)
@@ -115,7 +167,8 @@ func ProcessParallelSlicesBSP(pngImg *png.Image, numThreads int, effect string,
 
 	var mu sync.Mutex
 	var wg sync.WaitGroup
-	ctx := SharedContex{wgContext: &wg, mutex: &mu, cond: sync.NewCond(&mu), counter: 0, threadCount: actualNumThreads}
+	ctx := SharedContex{wgContext: &wg, mutex: &mu, cond: sync.NewCond(&mu), counter: 0, threadCount: actualNumThreads,
+		partials: make([][]interface{}, actualNumThreads), errs: make([]error, actualNumThreads)}
 
 	start := time.Now()
 
@@ -149,23 +202,45 @@ func ProcessParallelSlicesBSP(pngImg *png.Image, numThreads int, effect string,
 	ctx.wgContext.Wait()
 
 	end := time.Since(start).Seconds()
+	for _, err := range ctx.errs {
+		if err != nil {
+			return end, pngImg, err
+		}
+	}
+
+	// Every worker passed the barrier, combine what a Reduce pass counted before the next superstep
+	if effect.Reduce != nil {
+		var partials []interface{}
+		for _, own := range ctx.partials {
+			partials = append(partials, own...)
+		}
+		if err := mergePartials(effect, partials); err != nil {
+			return end, pngImg, err
+		}
+	}
 
-	return end, pngImg
+	return end, pngImg, nil
 }
 
 // ---------------- End Helper Function ---- //
 
 // Main function pop each image from q
-func RunParallelSlicesBSP(config Config, optimized bool) {
+func RunParallelSlicesBSP(config Config, optimized bool) (Results, error) {
 
 	// var pngImg *png.Image
 	var time_ float64
 
 	totalParallelTime := 0.0 //accumulate parallel time
 
-	queue, err := ReadTasksToQueue("../data/effects.txt", config.DataDirs)
+	queue, err := ReadTasksToQueue(config)
 	if err != nil {
-		panic(err) // Or handle error more gracefully
+		return nil, err
+	}
+
+	// Invalid tasks are reported first, the rest are processed
+	results, err := validateQueue(queue, config.EffectsPath, config.Strict)
+	if err != nil {
+		return nil, err
 	}
 
 	// pop image from queue
@@ -176,24 +251,52 @@ func RunParallelSlicesBSP(config Config, optimized bool) {
 		}
 		task := queue.Dequeue()
 
-		pngImg, err := png.Load(task.InPath)
+		pngImg, err := png.Load(task.InPath, config.LoadThreads)
 		if err != nil {
-			panic(err)
+			results = append(results, newResult(&task, fmt.Errorf("load: %w", err))) // skip to the next image
+			continue
+		}
+
+		effects, err := taskEffects(&task)
+		if err != nil {
+			results = append(results, newResult(&task, err))
+			continue
 		}
 
 		// Performs an effect on the image
-		for _, effect := range task.Effects {
-			time_, pngImg = ProcessParallelSlicesBSP(pngImg, config.ThreadCount, effect, optimized)
-			pngImg.In, pngImg.Out = pngImg.Out, pngImg.In //Swap pointers
+		for _, effect := range effects {
+			time_, pngImg, err = ProcessParallelSlicesBSP(pngImg, config.ThreadCount, effect, optimized)
+			if err != nil {
+				break
+			}
+			if !effect.NoSwap {
+				pngImg.Swap() //Swap pointers
+			}
 			totalParallelTime += time_
 		}
+		if err != nil {
+			results = append(results, newResult(&task, err))
+			continue
+		}
+
+		// each deque task counts its rows, the partial statistics are merged after the barrier
+		err = saveStats(&task, pngImg, func(effect png.Effect) error {
+			_, _, err := ProcessParallelSlicesBSP(pngImg, config.ThreadCount, effect, optimized)
+			return err
+		})
+		if err != nil {
+			results = append(results, newResult(&task, err))
+			continue
+		}
 
 		pngImg.Out, pngImg.In = pngImg.In, pngImg.Out
 		//Saves the image to a new file
-		err = pngImg.Save(task.OutPath)
+		err = saveImage(pngImg, &task)
 		if err != nil {
-			panic(err) // check for error while saving
+			err = fmt.Errorf("save: %w", err) // check for error while saving
 		}
+		results = append(results, newResult(&task, err))
 		fmt.Printf("Accumulate Parallel Time 10 images: %.2f seconds\n", totalParallelTime)
 	}
+	return results, nil
 }
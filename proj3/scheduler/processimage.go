@@ -1,37 +1,82 @@
 package scheduler
 
 import (
+	"encoding/json"
+	"fmt"
 	"proj3/png"
-	// "fmt"
 )
 
 // Take ImageTask that contains all image info and effects
-func ProcessImage(task *ImageTask) {
+// Returns an error instead of stopping the program so the caller can carry on with the other tasks.
+// A panic while processing the image is returned as an error too, so it does not stop the other tasks.
+func ProcessImage(task *ImageTask) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
 	// Load from path to return *Image
 	pngImg, err := png.Load(task.InPath)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("load: %w", err)
+	}
+
+	effects, err := taskEffects(task)
+	if err != nil {
+		return err
 	}
 
 	// Performs a X filtering effect on the image
-	for _, effect := range task.Effects {
-		// apply effect
-		switch effect {
-			case "G" :
-				pngImg.Grayscale()		// object from the same package call method in the same package no need to do png.Grayscale().
-															// note : The method call does not require the package name as a prefix; it only requires a valid instance of the type.
-			case "E":
-				pngImg.EdgeDetection()
-			case "S":
-				pngImg.Sharpen()
-			case "B":
-				pngImg.Blur()
+	for _, effect := range effects {
+		// apply effect registered in the png package on the whole image
+		if err := applyEffect(pngImg, effect); err != nil {
+			return err
+		}
+		if !effect.NoSwap {	// the pass left Out alone
+			pngImg.Swap()
 		}
-		pngImg.In, pngImg.Out = pngImg.Out, pngImg.In
+		}
+		if err := saveStats(task, pngImg, func(effect png.Effect) error { return applyEffect(pngImg, effect) }); err != nil {
+			return err
 		}
 		//Saves the image to a new file
 		pngImg.Out, pngImg.In = pngImg.In, pngImg.Out
-		err = pngImg.Save(task.OutPath); if err != nil {
-			panic(err)		// check for error while saving
+		err = saveImage(pngImg, task); if err != nil {
+			return fmt.Errorf("save: %w", err)		// check for error while saving
 		}
+		return nil
+}
+
+// applyEffect runs one pass on the whole image on the calling goroutine
+func applyEffect(pngImg *png.Image, effect png.Effect) error {
+	bounds, err := pngImg.Prepare(effect)
+	if err != nil {
+		return err
+	}
+	// a Reduce pass is a single slice here, merge its one partial result
+	partial, err := processImageSection(pngImg, bounds, effect)
+	if err == nil && partial != nil {
+		err = mergePartials(effect, []interface{}{partial})
+	}
+	return err
+}
+
+// saveImage writes the result in the format and with the options and text of the task
+func saveImage(pngImg *png.Image, task *ImageTask) error {
+	opts, err := task.saveOptions()
+	if err != nil {
+		return err
+	}
+
+	for _, keyword := range task.textKeywords() {
+		pngImg.Metadata.SetText(keyword, task.Text[keyword])
+	}
+	if task.RecordEffects {
+		effects, err := json.Marshal(task.Effects)
+		if err != nil {
+			return err
+		}
+		pngImg.Metadata.SetText("Effects", string(effects))
+	}
+	return pngImg.Save(task.OutPath, opts)
 }
@@ -1,31 +1,246 @@
 package scheduler
 
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"proj3/png"
+)
+
+// Default locations of the effects file and the data roots, relative to the
+// directory the editor is run from (proj3/editor or proj3/benchmark).
+const (
+	DefaultEffectsPath = "../data/effects.txt"
+	DefaultInDir       = "../data/in"
+	DefaultOutDir      = "../data/out"
+)
+
 type Config struct {
-	DataDirs string //Represents the data directories to use to load the images.
-	Mode     string // Represents which scheduler scheme to use
-	ThreadCount int // Runs parallel version with the specified number of threads
+	DataDirs    string //Represents the data directories to use to load the images.
+	Mode        string // Represents which scheduler scheme to use
+	ThreadCount int    // Runs parallel version with the specified number of threads
+	EffectsPath string // Path to the effects.txt file describing the tasks
+	InDir       string // Root holding the data directories the images are loaded from
+	OutDir      string // Root the output images are written to
+	Strict      bool   // Fail the whole run on an invalid task instead of skipping it
+	Stats       bool   // Write the statistics of each output image to a .json file next to it
+	LoadThreads int    // Goroutines converting a decoded image in the slice modes, 0 means ThreadCount
+	JPEGQuality int    // Quality of the JPEG outputs, 1-100, 0 means the encoder's default
+	Compression string // Compression of the PNG outputs: default, none, speed or best
+	Depth       string // Bit depth of the outputs: preserve, rgba8, rgba16, gray8 or gray16
+	// RecordEffects writes the effects applied into a text chunk of every PNG output
+	RecordEffects bool
 }
 
 // ImageTask details from effects.txt
 type ImageTask struct {
-	InPath  string   `json:"inPath"`
-	OutPath string   `json:"outPath"`
-	Effects []string `json:"effects"`
+	InPath  string       `json:"inPath"`
+	OutPath string       `json:"outPath"`
+	Effects []EffectSpec `json:"effects"`
+	Line    int          `json:"-"` // Line of effects.txt the task was read from
+	// StatsPath is where the statistics of the output are written, empty unless Config.Stats is set
+	StatsPath string `json:"-"`
+	// Encoder settings of this output, Config.JPEGQuality, Config.Compression and Config.Depth
+	// when not given. The format follows the extension of outPath.
+	Quality     int    `json:"quality,omitempty"`
+	Compression string `json:"compression,omitempty"`
+	Depth       string `json:"depth,omitempty"`
+
+	// Text is added to the text metadata of a PNG output, next to the chunks of the input.
+	// With RecordEffects the effects list is written under the keyword "Effects".
+	Text          map[string]string `json:"text,omitempty"`
+	RecordEffects bool              `json:"recordEffects,omitempty"`
+}
+
+// saveOptions returns the encoder settings of the task
+func (task *ImageTask) saveOptions() (png.SaveOptions, error) {
+	opts := png.SaveOptions{JPEGQuality: task.Quality}
+	if task.Compression != "" {
+		level, err := png.ParsePNGCompression(task.Compression)
+		if err != nil {
+			return opts, err
+		}
+		opts.PNGCompression = level
+	}
+	if task.Depth != "" {
+		depth, err := png.ParseDepth(task.Depth)
+		if err != nil {
+			return opts, err
+		}
+		opts.Depth = depth
+	}
+	return opts, png.CheckSaveOptions(opts)
+}
+
+// textKeywords returns the keywords of the task's text in sorted order, so the output
+// file and the validation errors do not depend on the map order
+func (task *ImageTask) textKeywords() []string {
+	keywords := make([]string, 0, len(task.Text))
+	for keyword := range task.Text {
+		keywords = append(keywords, keyword)
+	}
+	sort.Strings(keywords)
+	return keywords
+}
+
+// EffectSpec is one entry of the effects list. It is either the code of a registered
+// effect ie. "G", or an object holding the effect name and its parameters ie.
+// {"name": "kernel", "kernel": [[0,-1,0],[-1,5,-1],[0,-1,0]]}.
+// An object with a "kernel" field and no name is a kernel effect.
+type EffectSpec struct {
+	Name   string
+	Params json.RawMessage // The whole object, nil for a plain code
+}
+
+func (spec *EffectSpec) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '"' {
+		spec.Params = nil
+		return json.Unmarshal(data, &spec.Name)
+	}
+
+	var header struct {
+		Name   string          `json:"name"`
+		Kernel json.RawMessage `json:"kernel"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return fmt.Errorf("effect must be a code or an object: %w", err)
+	}
+	if header.Name == "" && header.Kernel != nil {
+		header.Name = "kernel"
+	}
+	if header.Name == "" {
+		return fmt.Errorf("effect object %s has no name", data)
+	}
+	spec.Name = header.Name
+	spec.Params = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+func (spec EffectSpec) MarshalJSON() ([]byte, error) {
+	if spec.Params != nil {
+		return spec.Params, nil
+	}
+	return json.Marshal(spec.Name)
+}
+
+// TaskStatus is the outcome of processing one ImageTask
+type TaskStatus int
+
+const (
+	StatusNotRun  TaskStatus = iota // No result was recorded, the zero value
+	StatusOK                        // The output image was written
+	StatusFailed                    // Loading, processing or saving the image failed
+	StatusSkipped                   // The task was invalid and never run (lenient mode)
+)
+
+func (s TaskStatus) String() string {
+	switch s {
+	case StatusNotRun:
+		return "not run"
+	case StatusOK:
+		return "ok"
+	case StatusFailed:
+		return "failed"
+	case StatusSkipped:
+		return "skipped"
+	}
+	return fmt.Sprintf("TaskStatus(%d)", int(s))
+}
+
+// TaskResult records what happened to one ImageTask
+type TaskResult struct {
+	InPath  string
+	OutPath string
+	Status  TaskStatus
+	Err     error // nil when Status is StatusOK
+}
+
+// Results is the per-task result set returned by Schedule.
+// Skipped tasks come first, then the processed tasks in task order.
+type Results []TaskResult
+
+// newResult builds the TaskResult of task from the error ProcessImage returned
+func newResult(task *ImageTask, err error) TaskResult {
+	result := TaskResult{InPath: task.InPath, OutPath: task.OutPath, Status: StatusOK}
+	if err != nil {
+		result.Status = StatusFailed
+		result.Err = err
+	}
+	return result
+}
+
+// count returns the number of results with the given status
+func (results Results) count(status TaskStatus) int {
+	n := 0
+	for _, result := range results {
+		if result.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Failed returns the number of tasks that were run but did not complete
+func (results Results) Failed() int {
+	return results.count(StatusFailed)
+}
+
+// Skipped returns the number of invalid tasks that were never run
+func (results Results) Skipped() int {
+	return results.count(StatusSkipped)
 }
 
-//Run the correct version based on the Mode field of the configuration value
-func Schedule(config Config) {
+// Summary returns a one line count of the results followed by one line per failed or skipped task
+func (results Results) Summary() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d tasks: %d ok, %d failed, %d skipped\n", len(results), results.count(StatusOK), results.Failed(), results.Skipped())
+	for _, result := range results {
+		if result.Status != StatusOK {
+			fmt.Fprintf(&sb, "  %s %s: %v\n", result.Status, result.InPath, result.Err)
+		}
+	}
+	return sb.String()
+}
+
+// withDefaults fills in any path left empty in the config with its default
+func (config Config) withDefaults() Config {
+	if config.EffectsPath == "" {
+		config.EffectsPath = DefaultEffectsPath
+	}
+	if config.InDir == "" {
+		config.InDir = DefaultInDir
+	}
+	if config.OutDir == "" {
+		config.OutDir = DefaultOutDir
+	}
+	if config.LoadThreads <= 0 {
+		config.LoadThreads = config.ThreadCount
+	}
+	return config
+}
+
+// Run the correct version based on the Mode field of the configuration value
+// A failing task is recorded in the returned Results and does not stop the others;
+// the error is only set when the run could not start (bad mode, unreadable effects file).
+func Schedule(config Config) (Results, error) {
+	config = config.withDefaults()
+	if config.Mode != "s" && config.ThreadCount < 1 {
+		return nil, fmt.Errorf("mode %q needs a thread count of at least 1, got %d", config.Mode, config.ThreadCount)
+	}
 	if config.Mode == "s" {
-		RunSequential(config)
+		return RunSequential(config)
 	} else if config.Mode == "parfiles" {
-		RunParallelFiles(config)
+		return RunParallelFiles(config)
 	} else if config.Mode == "parslices" {
-		RunParallelSlices(config)
+		return RunParallelSlices(config)
 	} else if config.Mode == "parslicesBSP" {
-		RunParallelSlicesBSP(config, false)
+		return RunParallelSlicesBSP(config, false)
 	} else if config.Mode == "parslicesBSPOptimized" {
-		RunParallelSlicesBSP(config, true)
-	} else {
-		panic("Invalid scheduling scheme given.")
+		return RunParallelSlicesBSP(config, true)
 	}
+	return nil, fmt.Errorf("invalid scheduling scheme %q given", config.Mode)
 }
@@ -0,0 +1,353 @@
+package scheduler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"proj3/png"
+)
+
+// defaultParams are the parameters of the registered effects that have no defaults
+var defaultParams = map[string]string{
+	"brightness": `{"name": "brightness", "amount": 20}`,
+	"contrast":   `{"name": "contrast", "amount": 1.5}`,
+	"gamma":      `{"name": "gamma", "gamma": 2.2}`,
+	"saturation": `{"name": "saturation", "amount": 0.5}`,
+	"hue":        `{"name": "hue", "degrees": 90}`,
+	"kernel":     `{"kernel": [[-2,-1,0],[-1,1,1],[0,1,2]], "bias": 16}`,
+	"percentile": `{"name": "percentile", "size": 5, "percentile": 25}`,
+	"crop":       `{"name": "crop", "x": 3, "y": 2, "width": 20, "height": 15}`,
+	"resize":     `{"name": "resize", "width": 50}`,
+}
+
+// chains are effect lists run on top of every registered effect on its own: the edge
+// modes, size changes in the middle of a chain and the reductions
+var chains = []string{
+	`[{"name": "B", "edge": "reflect"}, {"name": "S", "edge": "wrap"}]`,
+	`[{"name": "B", "edge": "constant", "color": [255, 0, 0, 128]}, {"name": "E", "edge": "skip"}]`,
+	`[{"name": "gaussian", "sigma": 2, "edge": "reflect"}, {"name": "gaussian", "sigma": 1.5, "edge": "wrap", "alpha": "straight"}]`,
+	`[{"name": "gaussian", "sigma": 1, "edge": "constant", "color": [0, 0, 255]}, {"name": "gaussian", "edge": "skip"}]`,
+	`[{"kernel": [[1,2,3,2,1],[0,1,0,1,0],[1,0,-4,0,1]], "edge": "reflect"}, {"name": "kernel", "row": [1,2,1], "column": [1,0,-1], "edge": "wrap", "bias": 128}]`,
+	`[{"name": "sobel", "direction": true, "edge": "wrap"}, {"name": "prewitt", "edge": "constant", "color": [255, 255, 255]}]`,
+	`[{"name": "median", "size": 5}, {"name": "open", "element": "disk", "size": 5}, {"name": "erode", "mask": [[0,1,0],[1,1,1],[0,1,0]], "binary": true}]`,
+	`[{"name": "resize", "width": 80, "sampling": "lanczos"}, "B", {"name": "resize", "height": 9, "sampling": "bicubic"}, "S"]`,
+	`[{"name": "rotate", "degrees": 30}, {"name": "crop", "x": 5, "y": 5, "width": 30, "height": 7}, {"name": "rotate", "degrees": 90}, "E"]`,
+	`["G", {"name": "equalize", "channels": "rgb"}, {"name": "clahe", "tiles": 3}, {"name": "canny", "sigma": 1}]`,
+}
+
+// sliceModes run one superstep the way each slice scheduler does, with a thread count
+// that does not divide the rows evenly
+var sliceModes = []struct {
+	name string
+	run  func(img *png.Image, effect png.Effect) error
+}{
+	{"parslices/3", func(img *png.Image, effect png.Effect) error {
+		_, _, err := ProcessParallelSlices(img, 3, effect)
+		return err
+	}},
+	{"parslices/7", func(img *png.Image, effect png.Effect) error {
+		_, _, err := ProcessParallelSlices(img, 7, effect)
+		return err
+	}},
+	{"parslicesBSP/3", func(img *png.Image, effect png.Effect) error {
+		_, _, err := ProcessParallelSlicesBSP(img, 3, effect, false)
+		return err
+	}},
+	{"parslicesBSPOptimized/7", func(img *png.Image, effect png.Effect) error {
+		_, _, err := ProcessParallelSlicesBSP(img, 7, effect, true)
+		return err
+	}},
+}
+
+// testSizes are the sizes of the input images: one where every slice split falls in the
+// middle of the picture, and ones narrower or shorter than the kernels
+var testSizes = []image.Point{{37, 23}, {1, 9}, {9, 1}, {2, 2}}
+
+// writeImage saves the premultiplied pixels as a 16-bit PNG
+func writeImage(t *testing.T, path string, pixels *image.RGBA64) {
+	img := &png.Image{In: image.NewRGBA64(pixels.Rect), Out: pixels, Bounds: pixels.Rect}
+	if err := img.Save(path, png.SaveOptions{Depth: png.DepthRGBA16}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestImage saves a w x h 16-bit image with varied color and alpha, some pixels
+// fully transparent
+func writeTestImage(t *testing.T, path string, w, h int) {
+	pixels := image.NewRGBA64(image.Rect(0, 0, w, h))
+	seed := uint32(1)
+	next := func() uint16 {
+		seed = seed*1664525 + 1013904223
+		return uint16(seed >> 16)
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, b, a := next(), next(), next(), next()
+			if x%3 == 0 {
+				a = 65535
+			} else if (x+y)%11 == 0 {
+				a = 0
+			}
+			pixels.SetRGBA64(x, y, color.RGBA64{premul(r, a), premul(g, a), premul(b, a), a})
+		}
+	}
+	writeImage(t, path, pixels)
+}
+
+func premul(v, a uint16) uint16 {
+	return uint16(uint32(v) * uint32(a) / 65535)
+}
+
+// testTasks returns one task per registered effect and one per chain
+func testTasks(t *testing.T) [][]EffectSpec {
+	var lists []string
+	for _, name := range png.EffectNames() {
+		if strings.HasPrefix(name, "test-") {
+			continue // registered by the tests themselves
+		}
+		if _, err := png.NewEffect(name, nil); err == nil {
+			lists = append(lists, fmt.Sprintf("[%q]", name))
+		} else if params, ok := defaultParams[name]; ok {
+			lists = append(lists, "["+params+"]")
+		} else {
+			t.Errorf("effect %q needs parameters, add them to defaultParams", name)
+		}
+	}
+	lists = append(lists, chains...)
+
+	tasks := make([][]EffectSpec, len(lists))
+	for i, list := range lists {
+		if err := json.Unmarshal([]byte(list), &tasks[i]); err != nil {
+			t.Fatalf("%s: %v", list, err)
+		}
+	}
+	return tasks
+}
+
+// runTask loads the input of task, runs its supersteps with run and saves the result and
+// its statistics like the schedulers do. It returns the image, the result in Out.
+func runTask(task *ImageTask, run func(img *png.Image, effect png.Effect) error) (*png.Image, error) {
+	pngImg, err := png.Load(task.InPath, 3)
+	if err != nil {
+		return nil, err
+	}
+	effects, err := taskEffects(task)
+	if err != nil {
+		return nil, err
+	}
+	for _, effect := range effects {
+		if err := run(pngImg, effect); err != nil {
+			return nil, err
+		}
+		if !effect.NoSwap {
+			pngImg.Swap()
+		}
+	}
+	if err := saveStats(task, pngImg, func(effect png.Effect) error { return run(pngImg, effect) }); err != nil {
+		return nil, err
+	}
+	pngImg.Out, pngImg.In = pngImg.In, pngImg.Out
+	return pngImg, saveImage(pngImg, task)
+}
+
+// sameFiles reports whether the two files hold the same bytes
+func sameFiles(t *testing.T, a, b string) bool {
+	dataA, err := os.ReadFile(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataB, err := os.ReadFile(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Equal(dataA, dataB)
+}
+
+// The slice schedulers must give the sequential result bit for bit, whatever the effect,
+// the size of the image and the number of threads: the pixels, the saved file and the statistics.
+func TestSliceModesMatchSequential(t *testing.T) {
+	for _, size := range testSizes {
+		t.Run(fmt.Sprintf("%dx%d", size.X, size.Y), func(t *testing.T) {
+			testSliceModes(t, size)
+		})
+	}
+}
+
+func testSliceModes(t *testing.T, size image.Point) {
+	dir := t.TempDir()
+	inPath := filepath.Join(dir, "in.png")
+	writeTestImage(t, inPath, size.X, size.Y)
+
+	for i, effects := range testTasks(t) {
+		label, _ := json.Marshal(effects)
+		newTask := func(mode string) *ImageTask {
+			base := filepath.Join(dir, fmt.Sprintf("%d_%s", i, strings.ReplaceAll(mode, "/", "_")))
+			return &ImageTask{InPath: inPath, OutPath: base + ".png", StatsPath: base + ".json", Effects: effects, Depth: "rgba16"}
+		}
+
+		processed := newTask("process")
+		if err := ProcessImage(processed); err != nil {
+			// ie. a crop outside a small image, every mode must fail the same way
+			for _, mode := range sliceModes {
+				if _, modeErr := runTask(newTask(mode.name), mode.run); modeErr == nil || modeErr.Error() != err.Error() {
+					t.Errorf("%s: %s: got error %v, want %v like ProcessImage", label, mode.name, modeErr, err)
+				}
+			}
+			continue
+		}
+		sequential := newTask("s")
+		want, err := runTask(sequential, applyEffect)
+		if err != nil {
+			t.Errorf("%s: sequential: %v", label, err)
+			continue
+		}
+		if !sameFiles(t, processed.OutPath, sequential.OutPath) || !sameFiles(t, processed.StatsPath, sequential.StatsPath) {
+			t.Errorf("%s: ProcessImage output differs from applying the supersteps in turn", label)
+		}
+
+		for _, mode := range sliceModes {
+			task := newTask(mode.name)
+			got, err := runTask(task, mode.run)
+			if err != nil {
+				t.Errorf("%s: %s: %v", label, mode.name, err)
+				continue
+			}
+			if got.Out.Bounds() != want.Out.Bounds() {
+				t.Errorf("%s: %s: bounds %v, want %v", label, mode.name, got.Out.Bounds(), want.Out.Bounds())
+				continue
+			}
+			if !bytes.Equal(got.Out.Pix, want.Out.Pix) {
+				t.Errorf("%s: %s: pixels differ from the sequential result", label, mode.name)
+			}
+			if !sameFiles(t, task.OutPath, processed.OutPath) {
+				t.Errorf("%s: %s: saved image differs from ProcessImage", label, mode.name)
+			}
+			if !sameFiles(t, task.StatsPath, processed.StatsPath) {
+				t.Errorf("%s: %s: statistics differ from ProcessImage", label, mode.name)
+			}
+		}
+	}
+}
+
+func init() {
+	// panics on one row only, so only one slice of the parallel modes fails
+	png.RegisterEffect(png.Effect{Name: "test-panic", Apply: func(img *png.Image, bounds image.Rectangle) {
+		if bounds.Min.Y <= 5 && 5 < bounds.Max.Y {
+			panic("row 5")
+		}
+	}})
+}
+
+// A panic in an effect fails the task it was applied to, in every mode, instead of the run
+func TestPanicFailsTheTask(t *testing.T) {
+	dir := t.TempDir()
+	inPath := filepath.Join(dir, "in.png")
+	writeTestImage(t, inPath, 37, 23)
+	effects := []EffectSpec{{Name: "B"}, {Name: "test-panic"}}
+
+	task := &ImageTask{InPath: inPath, OutPath: filepath.Join(dir, "process.png"), Effects: effects}
+	if err := ProcessImage(task); err == nil || !strings.Contains(err.Error(), "panic") {
+		t.Errorf("ProcessImage: got %v, want the panic as an error", err)
+	}
+	for _, mode := range sliceModes {
+		task := &ImageTask{InPath: inPath, OutPath: filepath.Join(dir, "slices.png"), Effects: effects}
+		if _, err := runTask(task, mode.run); err == nil || !strings.Contains(err.Error(), "test-panic: panic: row 5") {
+			t.Errorf("%s: got %v, want the panic as an error", mode.name, err)
+		}
+	}
+}
+
+// The Gaussian kernels are normalized, so a blur keeps the brightness of the image
+func TestGaussianWeightsSumToOne(t *testing.T) {
+	for _, sigma := range []float64{0.3, 1, 2.5, png.MaxSigma} {
+		kernel := png.GaussianKernel(sigma)
+		for _, weights := range [][]float64{kernel.Row, kernel.Column} {
+			sum := 0.0
+			for _, w := range weights {
+				sum += w
+			}
+			if math.Abs(sum-1) > 1e-12 {
+				t.Errorf("sigma %g: weights sum to %v, want 1", sigma, sum)
+			}
+		}
+	}
+}
+
+// A rotation by 90 degrees turns the image clockwise, the top left pixel goes to the
+// top right, in every mode
+func TestRotateIsClockwise(t *testing.T) {
+	dir := t.TempDir()
+	inPath := filepath.Join(dir, "in.png")
+	pixels := image.NewRGBA64(image.Rect(0, 0, 3, 2))
+	for i := range pixels.Pix {
+		pixels.Pix[i] = 0xff // opaque white
+	}
+	red := color.RGBA64{65535, 0, 0, 65535}
+	pixels.SetRGBA64(0, 0, red)
+	writeImage(t, inPath, pixels)
+	effects := []EffectSpec{{Name: "rotate", Params: json.RawMessage(`{"name": "rotate", "degrees": 90}`)}}
+
+	check := func(mode, path string) {
+		img, err := png.Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := img.In.Bounds(); got != image.Rect(0, 0, 2, 3) {
+			t.Errorf("%s: bounds %v, want 2x3", mode, got)
+			return
+		}
+		if got := img.In.RGBA64At(1, 0); got != red {
+			t.Errorf("%s: top right pixel %v, want the red top left pixel", mode, got)
+		}
+	}
+	task := &ImageTask{InPath: inPath, OutPath: filepath.Join(dir, "process.png"), Effects: effects}
+	if err := ProcessImage(task); err != nil {
+		t.Fatal(err)
+	}
+	check("ProcessImage", task.OutPath)
+	for _, mode := range sliceModes {
+		task := &ImageTask{InPath: inPath, OutPath: filepath.Join(dir, "slices.png"), Effects: effects}
+		if _, err := runTask(task, mode.run); err != nil {
+			t.Fatalf("%s: %v", mode.name, err)
+		}
+		check(mode.name, task.OutPath)
+	}
+}
+
+// The text of a task is written to the output and reads back from it, next to the text
+// of the input
+func TestTextRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	inPath := filepath.Join(dir, "in.png")
+	pixels := image.NewRGBA64(image.Rect(0, 0, 4, 3))
+	img := &png.Image{In: image.NewRGBA64(pixels.Rect), Out: pixels, Bounds: pixels.Rect}
+	img.Metadata.SetText("Author", "input")
+	img.Metadata.SetText("Title", "replaced")
+	if err := img.Save(inPath); err != nil {
+		t.Fatal(err)
+	}
+
+	text := map[string]string{"Title": "Café", "Comment": "plain ASCII"}
+	task := &ImageTask{InPath: inPath, OutPath: filepath.Join(dir, "out.png"), Effects: []EffectSpec{{Name: "G"}}, Text: text}
+	if err := ProcessImage(task); err != nil {
+		t.Fatal(err)
+	}
+	out, err := png.Load(task.OutPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"Author": "input", "Title": "Café", "Comment": "plain ASCII"}
+	for keyword, value := range want {
+		if got, ok := out.Metadata.Text(keyword); !ok || got != value {
+			t.Errorf("%s: got %q (found %v), want %q", keyword, got, ok, value)
+		}
+	}
+}
@@ -8,6 +8,7 @@ package scheduler
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 
 	"strings"
@@ -26,37 +27,54 @@ func ReadImageTasks(effectsPathFile string) ([]ImageTask, error) {
 	var tasks []ImageTask
 
 	scanner := bufio.NewScanner(effectsFile)			// Read line by line
+	line := 0
 	for scanner.Scan() {
+    line++
     var task ImageTask
     err := json.Unmarshal(scanner.Bytes(), &task)			// Unmarshal takes in []byte slice and copy into &task struct
     if err != nil {
-        return nil, err  // Handle the error appropriately
+        return nil, fmt.Errorf("%s:%d: %w", effectsPathFile, line, err)  // report which line is broken
     }
+    task.Line = line
     tasks = append(tasks, task)
 }
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
 	return tasks, nil
 }
 
 
 //Main Function
 // take in config which is userinput
-func RunSequential(config Config) {
+func RunSequential(config Config) (Results, error) {
 	// Load and put tasks in slice
-	tasks, err := ReadImageTasks("../data/effects.txt")		// return tasks slice
+	tasks, err := ReadImageTasks(config.EffectsPath)		// return tasks slice
 	if err != nil {
-		panic(err) // Or handle error more gracefully
+		return nil, err
 	}
 
 	// Assuming DataDirs might contain multiple directories separated by '+'
 	dataDirs := strings.Split(config.DataDirs, "+")
 
-	// Process each directory
+	// Expand the tasks for each directory
+	var dirTasks []ImageTask
 	for _, data_dir := range dataDirs {
 		for _, task := range tasks {
+			SetTaskPath(&task, config, data_dir)
+			dirTasks = append(dirTasks, task)
+		}
+	}
 
-			SetTaskPath(&task, data_dir)
+	// Check the effects before touching any image
+	dirTasks, results, err := validateTasks(dirTasks, config.EffectsPath, config.Strict)
+	if err != nil {
+		return nil, err
+	}
 
-			ProcessImage(&task)
-		}
+	for _, task := range dirTasks {
+		err := ProcessImage(&task)
+		results = append(results, newResult(&task, err))
 	}
+	return results, nil
 }
@@ -0,0 +1,30 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"proj3/png"
+)
+
+// saveStats computes the statistics of the result, still held in In, by running the
+// stats pass with run, and writes them to task.StatsPath. It does nothing if the path is empty.
+func saveStats(task *ImageTask, pngImg *png.Image, run func(png.Effect) error) error {
+	if task.StatsPath == "" {
+		return nil
+	}
+	var stats png.Stats
+	if err := run(png.StatsEffect(pngImg, &stats)); err != nil {
+		return fmt.Errorf("stats: %w", err)
+	}
+
+	data, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return fmt.Errorf("stats: %w", err)
+	}
+	if err := os.WriteFile(task.StatsPath, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("stats: %w", err)
+	}
+	return nil
+}
@@ -11,18 +11,34 @@ import (
 	"strings"
 )
 
-func SetTaskPath(task *ImageTask, data_dir string) {
+func SetTaskPath(task *ImageTask, config Config, data_dir string) {
 	// Set input output path
-	task.InPath = filepath.Join("../data/in", data_dir, task.InPath) // create inputpath .png
+	task.InPath = filepath.Join(config.InDir, data_dir, task.InPath) // create inputpath .png
 
 	// Create new outpath name
 	outFilename := filepath.Base(task.OutPath)
 	newOutFilename := fmt.Sprintf("%s_%s", data_dir, outFilename)
-	task.OutPath = filepath.Join("../data/out", newOutFilename) // create inputpath .png
+	task.OutPath = filepath.Join(config.OutDir, newOutFilename) // create outputpath .png
+
+	if task.Quality == 0 {
+		task.Quality = config.JPEGQuality
+	}
+	if task.Compression == "" {
+		task.Compression = config.Compression
+	}
+	if task.Depth == "" {
+		task.Depth = config.Depth
+	}
+	task.RecordEffects = task.RecordEffects || config.RecordEffects
+
+	if config.Stats {
+		task.StatsPath = strings.TrimSuffix(task.OutPath, filepath.Ext(task.OutPath)) + ".json"
+	}
 }
 
-func ReadTasksToQueue(effectsPathFile string, strDirs string) (*Queue, error) {
-	effectsFile, err := os.Open(effectsPathFile) // open file readonly mode
+// ReadTasksToQueue reads config.EffectsPath once per data directory in config.DataDirs
+func ReadTasksToQueue(config Config) (*Queue, error) {
+	effectsFile, err := os.Open(config.EffectsPath) // open file readonly mode
 	if err != nil {
 		return nil, err
 	}
@@ -31,7 +47,7 @@ func ReadTasksToQueue(effectsPathFile string, strDirs string) (*Queue, error) {
 	queue := NewQueue() // Your queue implementation
 
 	// loop over queue to add big/ .. small/ variations
-	dataDirs := strings.Split(strDirs, "+")
+	dataDirs := strings.Split(config.DataDirs, "+")
 
 	for _, data_dir := range dataDirs {
 		// Reset the file pointer to the beginning of the file before each scan
@@ -41,13 +57,16 @@ func ReadTasksToQueue(effectsPathFile string, strDirs string) (*Queue, error) {
 		}
 
 		scanner := bufio.NewScanner(effectsFile) // Read line by line
+		line := 0
 		for scanner.Scan() {
+			line++
 			var task ImageTask
 			err := json.Unmarshal(scanner.Bytes(), &task) // Unmarshal takes in []byte slice and copy into &task struct
 			if err != nil {
-				return nil, err // Handle the error appropriately
+				return nil, fmt.Errorf("%s:%d: %w", config.EffectsPath, line, err) // report which line is broken
 			}
-			SetTaskPath(&task, data_dir)
+			task.Line = line
+			SetTaskPath(&task, config, data_dir)
 			queue.Enqueue(task)
 		}
 
@@ -78,7 +97,7 @@ func SequentialEnque(workerId int, actualNumThreads int, tasksPerDeque int, task
 
 		if chunkStartY < height { // Tasks within the bounds
 			// fmt.Printf("startY: %d, endY: %d\n", chunkStartY, chunkEndY)
-			task := &deque.Task{Bounds: image.Rect(0, chunkStartY, bounds.Dx(), chunkEndY)}
+			task := &deque.Task{Bounds: image.Rect(bounds.Min.X, bounds.Min.Y+chunkStartY, bounds.Max.X, bounds.Min.Y+chunkEndY)}
 			if !dq[workerId].PushBottom(task) {
 				fmt.Println("Failed to push task to deque")
 			}
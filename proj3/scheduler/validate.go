@@ -0,0 +1,83 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"proj3/png"
+)
+
+// ValidateTask checks that every effect listed in the task is registered in the png package
+// and that its parameters are valid.
+// Without this an unknown code (ie. "g") would make the task fail halfway through.
+func ValidateTask(task *ImageTask) error {
+	if _, err := taskEffects(task); err != nil {
+		return err
+	}
+	// the output format and its options are checked too, so no image is processed for nothing
+	if err := png.CheckSavePath(task.OutPath); err != nil {
+		return fmt.Errorf("outPath: %w", err)
+	}
+	if _, err := task.saveOptions(); err != nil {
+		return err
+	}
+	for _, keyword := range task.textKeywords() {
+		if err := png.CheckKeyword(keyword); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// taskEffects builds the registered png.Effect for each effect of the task and returns
+// their supersteps in order, so a compound effect shows up as several effects.
+func taskEffects(task *ImageTask) ([]png.Effect, error) {
+	var steps []png.Effect
+	for i, spec := range task.Effects {
+		effect, err := png.NewEffect(spec.Name, spec.Params)
+		if err != nil {
+			return nil, fmt.Errorf("effects[%d]: %w", i, err)
+		}
+		steps = append(steps, effect.Supersteps()...)
+	}
+	return steps, nil
+}
+
+// validateTasks splits tasks into the valid ones and a StatusSkipped result per invalid one.
+// In strict mode any invalid task is an error instead, naming every bad line of effectsPath.
+func validateTasks(tasks []ImageTask, effectsPath string, strict bool) ([]ImageTask, Results, error) {
+	var valid []ImageTask
+	var skipped Results
+	var errs []string
+	reported := make(map[int]bool) // the same line shows up once per data directory
+
+	for i := range tasks {
+		err := ValidateTask(&tasks[i])
+		if err == nil {
+			valid = append(valid, tasks[i])
+			continue
+		}
+		err = fmt.Errorf("%s:%d: %w", effectsPath, tasks[i].Line, err)
+		if !reported[tasks[i].Line] {
+			reported[tasks[i].Line] = true
+			errs = append(errs, err.Error())
+		}
+		skipped = append(skipped, TaskResult{InPath: tasks[i].InPath, OutPath: tasks[i].OutPath, Status: StatusSkipped, Err: err})
+	}
+
+	if strict && len(errs) > 0 {
+		return nil, nil, errors.New(strings.Join(errs, "\n"))
+	}
+	return valid, skipped, nil
+}
+
+// validateQueue drops the invalid tasks from the queue, see validateTasks
+func validateQueue(queue *Queue, effectsPath string, strict bool) (Results, error) {
+	valid, skipped, err := validateTasks(queue.GetTasks(), effectsPath, strict)
+	if err != nil {
+		return nil, err
+	}
+	queue.tasks = valid
+	return skipped, nil
+}